@@ -0,0 +1,264 @@
+package bartlett
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	sqrl "github.com/Masterminds/squirrel"
+	"net/http"
+	"time"
+)
+
+// Audit configures the optional record of every INSERT, UPDATE and DELETE made through Bartlett.
+// Sink receives the records; leave it nil to disable auditing.
+// Images adds full copies of the affected rows before and after the write, at the cost of extra queries.
+type Audit struct {
+	Sink   AuditSink
+	Images bool
+}
+
+// An AuditRecord describes one write.
+// Keys holds the primary keys of the affected rows, when the table has a single-column primary key.
+// For updates and deletes they are found by running the request's filters as a SELECT just before the write.
+// Before and After are only filled in when Audit.Images is set; inserts have no Before and deletes no After.
+type AuditRecord struct {
+	Time      time.Time                `json:"time"`
+	Table     string                   `json:"table"`
+	Operation Operation                `json:"operation"`
+	UserID    interface{}              `json:"user_id"`
+	SQL       string                   `json:"sql"`
+	Args      []interface{}            `json:"args"`
+	Keys      []interface{}            `json:"keys"`
+	Before    []map[string]interface{} `json:"before,omitempty"`
+	After     []map[string]interface{} `json:"after,omitempty"`
+}
+
+// An AuditSink stores audit records.
+// Record is called inside the write's transaction just before it commits. Returning an error rolls the write back.
+type AuditSink interface {
+	Record(ctx context.Context, tx *sql.Tx, rec AuditRecord) error
+}
+
+// AuditFunc adapts an ordinary function to an AuditSink.
+type AuditFunc func(ctx context.Context, tx *sql.Tx, rec AuditRecord) error
+
+// Record calls f.
+func (f AuditFunc) Record(ctx context.Context, tx *sql.Tx, rec AuditRecord) error {
+	return f(ctx, tx, rec)
+}
+
+// AuditChannel sends each record to a channel.
+// Records are sent before the transaction commits, so a consumer may rarely see a write whose commit then failed.
+// A full channel blocks the write until there is room or the request's context ends.
+type AuditChannel chan<- AuditRecord
+
+// Record sends rec to the channel.
+func (c AuditChannel) Record(ctx context.Context, _ *sql.Tx, rec AuditRecord) error {
+	select {
+	case c <- rec:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// AuditTable writes each record to a table in the same transaction as the write itself.
+// The table needs the columns table_name, operation, user_id, statement, args, row_keys,
+// before_image, after_image and created_at. Args, keys and images are stored as JSON text.
+type AuditTable struct {
+	Name string
+}
+
+// Record inserts rec into the audit table.
+func (a AuditTable) Record(ctx context.Context, tx *sql.Tx, rec AuditRecord) error {
+	args, err := json.Marshal(rec.Args)
+	if err != nil {
+		return err
+	}
+	keys, err := json.Marshal(rec.Keys)
+	if err != nil {
+		return err
+	}
+	before, err := json.Marshal(rec.Before)
+	if err != nil {
+		return err
+	}
+	after, err := json.Marshal(rec.After)
+	if err != nil {
+		return err
+	}
+
+	_, err = sqrl.Insert(a.Name).
+		Columns(`table_name`, `operation`, `user_id`, `statement`, `args`, `row_keys`, `before_image`, `after_image`, `created_at`).
+		Values(rec.Table, string(rec.Operation), rec.UserID, rec.SQL, string(args), string(keys), string(before), string(after), rec.Time).
+		RunWith(tx).
+		ExecContext(ctx)
+	return err
+}
+
+// captureBefore starts the record of a write, capturing the affected keys and rows before anything changes.
+// The record feeds both the audit log and the change feed. It is nil when neither is enabled.
+// The keys are also given to After hooks as Call.IDs.
+func (b Bartlett) captureBefore(ctx context.Context, tx *sql.Tx, c *Call) (*AuditRecord, error) {
+	if b.Audit.Sink == nil && b.Changes == nil {
+		return nil, b.captureIDs(ctx, tx, c)
+	}
+
+	rec := &AuditRecord{
+		Time:      time.Now(),
+		Table:     c.Table.Name,
+		Operation: c.Operation,
+		UserID:    b.auditUser(c.Request),
+	}
+
+	var err error
+	rec.SQL, rec.Args, err = c.statement().ToSql()
+	if c.Operation == OpInsert {
+		return rec, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Deleted rows can't be looked up afterwards, so the change feed needs their image to check who may see them.
+	images := b.Audit.Images || (b.Changes != nil && c.Operation == OpDelete)
+	key := c.Table.primaryKey()
+	if key == `` && !images {
+		return rec, nil
+	}
+
+	columns := `*`
+	if !images {
+		columns = c.Table.quote(key)
+	}
+	rows, err := scanRows(ctx, tx, c.targetRows(columns))
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if key != `` {
+			rec.Keys = append(rec.Keys, row[key])
+		}
+	}
+	if images {
+		rec.Before = rows
+	}
+	c.IDs = rec.Keys
+
+	return rec, nil
+}
+
+// captureIDs reads the keys of the rows an update or delete targets for its After hooks, when there is no audit
+// record to read them for.
+func (b Bartlett) captureIDs(ctx context.Context, tx *sql.Tx, c *Call) error {
+	key := c.Table.primaryKey()
+	if c.Operation == OpInsert || key == `` || !c.Table.hasAfter(c.Operation) {
+		return nil
+	}
+
+	rows, err := scanRows(ctx, tx, c.targetRows(c.Table.quote(key)))
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		c.IDs = append(c.IDs, row[key])
+	}
+
+	return nil
+}
+
+// targetRows selects the rows a write through r applies to, as its own WHERE, ORDER BY, LIMIT and UserID would.
+func (b Bartlett) targetRows(t Table, r *http.Request, columns string) sqrl.SelectBuilder {
+	query := selectWhere(sqrl.Select(columns).From(t.sqlName()), t, r)
+	query = selectOrder(query, t, r)
+	query = selectLimit(query, r)
+	if t.UserID != `` {
+		query = query.Where(sqrl.Eq{t.quote(t.UserID): b.auditUser(r)})
+	}
+	if filter, ok := t.deletedFilter(r); ok {
+		query = query.Where(filter)
+	}
+
+	return query
+}
+
+// captureAfter completes the record once the write has run and hands it to the audit sink.
+func (b Bartlett) captureAfter(ctx context.Context, tx *sql.Tx, c *Call, rec *AuditRecord) error {
+	if rec == nil {
+		return nil
+	}
+
+	if c.Operation == OpInsert {
+		rec.Keys = c.IDs
+	}
+
+	key := c.Table.primaryKey()
+	if b.Audit.Images && c.Operation != OpDelete && key != `` && len(rec.Keys) > 0 {
+		var err error
+		rec.After, err = scanRows(ctx, tx, sqrl.Select(`*`).From(c.Table.sqlName()).Where(sqrl.Eq{c.Table.quote(key): rec.Keys}))
+		if err != nil {
+			return err
+		}
+	}
+
+	if b.Audit.Sink == nil {
+		return nil
+	}
+	audited := *rec
+	if !b.Audit.Images {
+		audited.Before = nil // Captured for the change feed only.
+	}
+
+	return b.Audit.Sink.Record(ctx, tx, audited)
+}
+
+// auditUser asks the UserIDProvider who made the request, whether or not the table is scoped by user.
+func (b Bartlett) auditUser(r *http.Request) interface{} {
+	if b.Users == nil {
+		return nil
+	}
+	userID, err := b.Users(r)
+	if err != nil {
+		return nil
+	}
+
+	return userID
+}
+
+// scanRows runs query in tx and returns each row as a map from column name to value.
+func scanRows(ctx context.Context, tx *sql.Tx, query sqrl.SelectBuilder) ([]map[string]interface{}, error) {
+	rows, err := query.RunWith(tx).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var out []map[string]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		err = rows.Scan(pointers...)
+		if err != nil {
+			return nil, err
+		}
+
+		row := make(map[string]interface{}, len(columns))
+		for i, col := range columns {
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b) // Keep text readable instead of base64 when the record is marshaled.
+			}
+			row[col] = values[i]
+		}
+		out = append(out, row)
+	}
+
+	return out, rows.Err()
+}
@@ -0,0 +1,144 @@
+package bartlett
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuditDelete(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	records := make(chan AuditRecord, 1)
+	b := Bartlett{
+		DB:     db,
+		Driver: dummyDriver{},
+		Tables: []Table{
+			{Name: `students`, Writable: true},
+		},
+		Users: dummyUserProvider,
+		Audit: Audit{Sink: AuditChannel(records)},
+	}
+
+	routes := b.Routes()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM students WHERE name = \?`).
+		WithArgs(`todd`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`}).AddRow(4).AddRow(9))
+	mock.ExpectExec(`DELETE FROM students WHERE name = \?`).
+		WithArgs(`todd`).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	resp := httptest.NewRecorder()
+	routes[0].Handler(resp, httptest.NewRequest(http.MethodDelete, `/students?name=eq.todd`, nil))
+	if resp.Code != http.StatusOK {
+		t.Fatalf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	rec := <-records
+	if rec.Table != `students` || rec.Operation != OpDelete || rec.UserID != 1 {
+		t.Errorf(`Expected delete on students by user 1 but got %+v`, rec)
+	}
+	if !strings.HasPrefix(rec.SQL, `DELETE FROM students`) || rec.Args[0] != `todd` {
+		t.Errorf(`Expected compiled DELETE but got %s %+v`, rec.SQL, rec.Args)
+	}
+	if fmt.Sprint(rec.Keys) != `[4 9]` {
+		t.Errorf(`Expected keys [4 9] but got %+v`, rec.Keys)
+	}
+	if rec.Before != nil {
+		t.Errorf(`Expected no row images but got %+v`, rec.Before)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestAuditUpdateImages(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	b := Bartlett{
+		DB:     db,
+		Driver: dummyDriver{},
+		Tables: []Table{
+			{Name: `students`, Writable: true},
+		},
+		Users: dummyUserProvider,
+		Audit: Audit{Sink: AuditTable{Name: `audit_log`}, Images: true},
+	}
+
+	routes := b.Routes()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM students WHERE id = \?`).
+		WithArgs(`4`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`, `name`}).AddRow(4, `todd`))
+	mock.ExpectExec(`UPDATE students SET name = \? WHERE id = \?`).
+		WithArgs([]uint8(`ned`), `4`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT \* FROM students WHERE id IN \(\?\)`).
+		WithArgs(int64(4)).
+		WillReturnRows(sqlmock.NewRows([]string{`id`, `name`}).AddRow(4, `ned`))
+	mock.ExpectExec(`INSERT INTO audit_log \(table_name,operation,user_id,statement,args,row_keys,before_image,after_image,created_at\)`).
+		WithArgs(`students`, `update`, 1, sqlmock.AnyArg(), sqlmock.AnyArg(), `[4]`, `[{"id":4,"name":"todd"}]`, `[{"id":4,"name":"ned"}]`, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	resp := httptest.NewRecorder()
+	routes[0].Handler(resp, httptest.NewRequest(http.MethodPatch, `/students?id=eq.4`, strings.NewReader(`{"name":"ned"}`)))
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestAuditInsertFails(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	var recorded []AuditRecord
+	b := Bartlett{
+		DB:     db,
+		Driver: dummyDriver{},
+		Tables: []Table{
+			{Name: `letters`, Writable: true},
+		},
+		Users: dummyUserProvider,
+		Audit: Audit{Sink: AuditFunc(func(_ context.Context, _ *sql.Tx, rec AuditRecord) error {
+			recorded = append(recorded, rec)
+			return errors.New(`audit log is full`)
+		})},
+	}
+
+	routes := b.Routes()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO letters`).WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectRollback()
+	resp := httptest.NewRecorder()
+	routes[0].Handler(resp, httptest.NewRequest(http.MethodPost, `/letters`, strings.NewReader(`{"a":"hello"}`)))
+	if resp.Code != http.StatusInternalServerError {
+		t.Errorf(`Expected "500" but got %d for status code`, resp.Code)
+	}
+	if len(recorded) != 1 || recorded[0].Operation != OpInsert || recorded[0].Keys[0] != int64(3) {
+		t.Errorf(`Expected one insert record with key 3 but got %+v`, recorded)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
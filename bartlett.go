@@ -2,31 +2,161 @@
 package bartlett
 
 import (
+	"context"
 	"database/sql"
 	"net/http"
+	"path"
+	"strings"
+	"time"
 )
 
 // A UserIDProvider is a function that is able to use an incoming request to produce a user ID.
 type UserIDProvider func(r *http.Request) (interface{}, error)
 
 // Bartlett holds all of the configuration necessary to generate an API from the database.
+// Timeout limits how long any single request may spend in the database. Zero means no limit.
+// Tables may override it with their own Timeout.
+// Audit records every write made through the API when its Sink is set.
+// Changes enables `GET /<table>?subscribe` change feeds; a ChangeFeed also receives the API's own writes.
+// Cache, if set, answers repeated GET requests from memory until a write through the API changes their table.
+// Strict refuses requests that name columns or parameters a table doesn't have, instead of ignoring them.
+// Encoders, if set, chooses how values of particular SQL types or columns are marshaled, such as DECIMAL as a number.
+// Idempotency, if set, remembers the responses to POST requests with an `Idempotency-Key` header and replays them.
 type Bartlett struct {
-	DB     *sql.DB
-	Driver Driver
-	Tables []Table
-	Users  UserIDProvider
+	DB          *sql.DB
+	Driver      Driver
+	Tables      []Table
+	Users       UserIDProvider
+	Timeout     time.Duration
+	Audit       Audit
+	Changes     ChangeSource
+	Cache       *ResponseCache
+	Strict      bool
+	Idempotency IdempotencyStore
+	Encoders    *Encoders
+	state       *tableState
 }
 
-func (b *Bartlett) ProbeTables(writable bool) *Bartlett {
-	tables := b.Driver.ProbeTables(b.DB)
+// ProbeOptions controls which tables ProbeTables adds and how they are configured.
+// Include and Exclude are glob patterns in the syntax of path.Match, checked against the table name.
+// An empty Include matches every table. Exclude wins over Include.
+// Schema selects a database other than the connection's default. Probed tables from it are named `schema.table`.
+// Writable, UserID and IDGenerator are applied to every probed table.
+// UserID is only set on tables that actually have a column of that name.
+// When IDGenerator is set, a table with a single-column primary key uses it as its IDColumn.
+type ProbeOptions struct {
+	Include     []string
+	Exclude     []string
+	Schema      string
+	Writable    bool
+	UserID      string
+	IDGenerator func() interface{}
+}
+
+// ProbeTables asks the Driver for every table in the database and adds the ones matching opts.
+// Tables already present in Bartlett.Tables are left as they are.
+// The options are remembered so that Refresh can pick up tables created later.
+func (b *Bartlett) ProbeTables(ctx context.Context, opts ProbeOptions) error {
+	tables, err := b.probe(ctx, opts, b.hasTable)
+	if err != nil {
+		return err
+	}
+
+	b.Tables = append(b.Tables, tables...)
+	b.init().addProbe(opts)
+
+	return nil
+}
+
+// probe returns the tables matching opts that are not already known.
+func (b *Bartlett) probe(ctx context.Context, opts ProbeOptions, known func(name string) bool) ([]Table, error) {
+	tables, err := b.Driver.ProbeTables(ctx, b.DB, opts.Schema)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Table
 	for _, tbl := range tables {
-		if !b.hasTable(tbl.Name) {
-			tbl.Writable = writable
-			b.Tables = append(b.Tables, tbl)
+		if known(tbl.Name) {
+			continue
+		}
+		match, err := opts.match(tbl.Name)
+		if err != nil {
+			return nil, err
+		}
+		if !match {
+			continue
+		}
+
+		tbl.Writable = opts.Writable
+		if opts.UserID != `` || opts.IDGenerator != nil {
+			columns, err := b.Driver.GetColumns(ctx, b.DB, tbl)
+			if err != nil {
+				return nil, err
+			}
+			opts.applyDefaults(&tbl, columns)
+		}
+		out = append(out, tbl)
+	}
+
+	return out, nil
+}
+
+func (opts ProbeOptions) match(name string) (bool, error) {
+	if opts.Schema != `` {
+		name = strings.TrimPrefix(name, opts.Schema+`.`) // Patterns are written against the bare table name.
+	}
+
+	included := len(opts.Include) == 0
+	for _, pattern := range opts.Include {
+		match, err := path.Match(pattern, name)
+		if err != nil {
+			return false, err
+		}
+		included = included || match
+	}
+
+	for _, pattern := range opts.Exclude {
+		match, err := path.Match(pattern, name)
+		if err != nil {
+			return false, err
+		}
+		if match {
+			return false, nil
+		}
+	}
+
+	return included, nil
+}
+
+func (opts ProbeOptions) applyDefaults(t *Table, columns []Column) {
+	var keys []string
+	for _, col := range columns {
+		if col.Name == opts.UserID {
+			t.UserID = col.Name
+		}
+		if col.PrimaryKey {
+			keys = append(keys, col.Name)
 		}
 	}
 
-	return b
+	if opts.IDGenerator != nil && len(keys) == 1 {
+		t.IDColumn = IDSpec{Name: keys[0], Generator: opts.IDGenerator}
+	}
+}
+
+// context derives the context for a request's queries, applying the table or global statement timeout.
+// The request context is cancelled when the client disconnects, which in turn cancels the query.
+func (b Bartlett) context(t Table, r *http.Request) (context.Context, context.CancelFunc) {
+	timeout := b.Timeout
+	if t.Timeout > 0 {
+		timeout = t.Timeout
+	}
+	if timeout > 0 {
+		return context.WithTimeout(r.Context(), timeout)
+	}
+
+	return context.WithCancel(r.Context())
 }
 
 func (b *Bartlett) hasTable(name string) bool {
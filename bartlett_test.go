@@ -1,6 +1,7 @@
 package bartlett
 
 import (
+	"context"
 	"database/sql"
 	"testing"
 )
@@ -14,7 +15,10 @@ func TestBartlett_ProbeTables(t *testing.T) {
 	}
 
 	b := Bartlett{DB: &sql.DB{}, Driver: dummyDriver{}, Tables: tables, Users: dummyUserProvider}
-	b.ProbeTables(false)
+	err := b.ProbeTables(context.Background(), ProbeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
 	if b.Tables[0].Name != `students` || b.Tables[0].Writable != true {
 		t.Errorf(`Expected students to be writable but got %s as %t`, b.Tables[0].Name, b.Tables[0].Writable)
 	}
@@ -22,3 +26,54 @@ func TestBartlett_ProbeTables(t *testing.T) {
 		t.Errorf(`Expected teachers to be non-writable but got %s as %t`, b.Tables[0].Name, b.Tables[0].Writable)
 	}
 }
+
+func TestBartlett_ProbeTablesFilter(t *testing.T) {
+	b := Bartlett{DB: &sql.DB{}, Driver: dummyDriver{}, Users: dummyUserProvider}
+	err := b.ProbeTables(context.Background(), ProbeOptions{Include: []string{`*s`}, Exclude: []string{`stu*`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Tables) != 1 || b.Tables[0].Name != `teachers` {
+		t.Errorf(`Expected only teachers but got %+v`, b.Tables)
+	}
+
+	b = Bartlett{DB: &sql.DB{}, Driver: dummyDriver{}, Users: dummyUserProvider}
+	err = b.ProbeTables(context.Background(), ProbeOptions{Schema: `archive_2020`, Include: []string{`teachers`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Tables) != 1 || b.Tables[0].Name != `teachers` {
+		t.Errorf(`Expected names without the schema prefix to be matched as they are but got %+v`, b.Tables)
+	}
+
+	err = b.ProbeTables(context.Background(), ProbeOptions{Include: []string{`[`}})
+	if err == nil {
+		t.Error(`Expected malformed pattern to produce an error but got nil`)
+	}
+}
+
+func TestBartlett_ProbeTablesDefaults(t *testing.T) {
+	b := Bartlett{DB: &sql.DB{}, Driver: dummyDriver{}, Users: dummyUserProvider}
+	err := b.ProbeTables(context.Background(), ProbeOptions{
+		Writable:    true,
+		UserID:      `name`,
+		IDGenerator: func() interface{} { return 1 },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tbl := range b.Tables {
+		if !tbl.Writable || tbl.UserID != `name` || tbl.IDColumn.Name != `id` {
+			t.Errorf(`Expected writable table with UserID "name" and IDColumn "id" but got %+v`, tbl)
+		}
+	}
+
+	b = Bartlett{DB: &sql.DB{}, Driver: dummyDriver{}, Users: dummyUserProvider}
+	err = b.ProbeTables(context.Background(), ProbeOptions{UserID: `owner_id`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Tables[0].UserID != `` {
+		t.Errorf(`Expected no UserID for a table without that column but got %s`, b.Tables[0].UserID)
+	}
+}
@@ -0,0 +1,243 @@
+package bartlett
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	sqrl "github.com/Masterminds/squirrel"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+)
+
+// batchKey marks a request that runs as one operation of a batch.
+type batchKey struct{}
+
+// A batch is the transaction shared by the operations of one `/batch` request.
+// Work that must wait until the writes are visible, such as cache invalidation, is deferred until it commits.
+type batch struct {
+	tx        *sql.Tx
+	committed []func()
+}
+
+func batchOf(r *http.Request) (*batch, bool) {
+	bt, ok := r.Context().Value(batchKey{}).(*batch)
+	return bt, ok
+}
+
+// begin starts the transaction for a write, or joins the batch the request is part of.
+func (b Bartlett) begin(ctx context.Context, r *http.Request) (*sql.Tx, error) {
+	if bt, ok := batchOf(r); ok {
+		return bt.tx, nil
+	}
+	return b.DB.BeginTx(ctx, nil)
+}
+
+// rollback abandons a write's own transaction. A batch is rolled back as a whole by handleBatch.
+func rollback(r *http.Request, tx *sql.Tx) {
+	if _, ok := batchOf(r); !ok {
+		_ = tx.Rollback() // No effect once the transaction is committed.
+	}
+}
+
+// commit commits a write's own transaction and then calls done. Within a batch, both wait until the batch commits.
+func commit(r *http.Request, tx *sql.Tx, done func()) error {
+	if bt, ok := batchOf(r); ok {
+		bt.committed = append(bt.committed, done)
+		return nil
+	}
+	err := tx.Commit()
+	if err == nil {
+		done()
+	}
+	return err
+}
+
+// runner is where a read runs: the database, or the transaction of its batch so that it sees the batch's writes.
+func (b Bartlett) runner(r *http.Request) sqrl.BaseRunner {
+	if bt, ok := batchOf(r); ok {
+		return bt.tx
+	}
+	return b.DB
+}
+
+// batchRef is a reference to the IDs inserted by an earlier operation, eg `${parent}` or `${parent.1}` for the second.
+// In a body, a reference must be a whole string value, which is replaced by the ID itself.
+var (
+	batchRef     = regexp.MustCompile(`\$\{([^}.]+)(?:\.(\d+))?\}`)
+	batchBodyRef = regexp.MustCompile(`"\$\{([^}."]+)(?:\.(\d+))?\}"`)
+)
+
+// handleBatch runs a list of operations in order, in a single transaction, and commits only if all of them succeed.
+// Operations take the same form as WebSocket messages. The response lists each operation's status and body.
+// If one fails, the transaction is rolled back, the operations after it are not run,
+// and the batch responds with the failed operation's status.
+func (b Bartlett) handleBatch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(`Content-Type`, `application/json`)
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf(`method %s not allowed on batch`, r.Method))
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	var ops []wsRequest
+	err := json.Unmarshal(body, &ops)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf(`batch should be an array of operations: %v`, err))
+		return
+	}
+
+	ctx, cancel := b.context(Table{}, r)
+	defer cancel()
+
+	tx, err := b.DB.BeginTx(ctx, nil)
+	if err != nil {
+		queryError(ctx, w, err)
+		return
+	}
+	defer tx.Rollback() // No effect once the transaction is committed.
+
+	bt := &batch{tx: tx}
+	inserted := make(map[string][]interface{})
+	results := make([]wsResponse, 0, len(ops))
+	status := http.StatusOK
+	for i, op := range ops {
+		if op.ID == `` {
+			op.ID = strconv.Itoa(i) // Operations without an ID are referred to by position.
+		}
+		res := b.runBatchOp(r.WithContext(context.WithValue(ctx, batchKey{}, bt)), op, inserted)
+		results = append(results, res)
+		if res.Status >= 300 {
+			status = res.Status
+			break
+		}
+		if _, ok := inserted[op.ID]; !ok && op.Op == `insert` {
+			inserted[op.ID] = insertedIDs(res.Body)
+		}
+	}
+
+	if status == http.StatusOK {
+		err = tx.Commit()
+		if err != nil {
+			queryError(ctx, w, err)
+			return
+		}
+		for _, done := range bt.committed {
+			done()
+		}
+	}
+
+	out, err := json.Marshal(results)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(status)
+	_, _ = w.Write(out)
+}
+
+// runBatchOp runs one operation the way handleRoute would, after filling in references to earlier inserts.
+// An operation that reports errors for some of its rows counts as failed, since the batch is all or nothing.
+func (b Bartlett) runBatchOp(r *http.Request, op wsRequest, inserted map[string][]interface{}) wsResponse {
+	method, ok := wsMethods[op.Op]
+	if !ok {
+		return batchError(op.ID, http.StatusBadRequest, fmt.Errorf(`unknown op %q`, op.Op))
+	}
+
+	var refErr error
+	resolve := func(pattern *regexp.Regexp, raw []byte, encode func(interface{}) []byte) []byte {
+		return pattern.ReplaceAllFunc(raw, func(ref []byte) []byte {
+			match := pattern.FindSubmatch(ref)
+			ids, ok := inserted[string(match[1])]
+			index, _ := strconv.Atoi(string(match[2]))
+			if !ok || index >= len(ids) {
+				refErr = fmt.Errorf(`%s does not name an earlier insert`, ref)
+				return ref
+			}
+			return encode(ids[index])
+		})
+	}
+	key := resolve(batchRef, []byte(op.Key), func(id interface{}) []byte { return []byte(fmt.Sprint(id)) })
+	query := resolve(batchRef, []byte(op.Query), func(id interface{}) []byte { return []byte(url.QueryEscape(fmt.Sprint(id))) })
+	body := resolve(batchBodyRef, op.Body, func(id interface{}) []byte {
+		out, _ := json.Marshal(id)
+		return out
+	})
+	if refErr != nil {
+		return batchError(op.ID, http.StatusBadRequest, refErr)
+	}
+
+	req := r.Clone(r.Context())
+	req.Method = method
+	req.URL.Path = `/` + op.Table
+	req.URL.RawQuery = string(query)
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	for _, h := range []string{`Accept`, `If-Match`, `If-None-Match`, `If-Modified-Since`, `Idempotency-Key`} {
+		req.Header.Del(h) // The batch request's headers say nothing about a single operation.
+	}
+	if len(key) > 0 {
+		req = withItem(req, string(key))
+	}
+
+	buf := newBufferedWriter()
+	b.handleRoute(op.Table)(buf, req)
+
+	out := buf.body.Bytes()
+	if !json.Valid(out) {
+		out = nil
+	}
+	res := wsResponse{ID: op.ID, Status: buf.status, Body: out}
+	if res.Status < 300 && partialFailure(method, out) {
+		res.Status = http.StatusUnprocessableEntity
+	}
+
+	return res
+}
+
+func batchError(id string, status int, err error) wsResponse {
+	body, _ := json.Marshal(struct {
+		Error string `json:"error"`
+	}{err.Error()})
+	return wsResponse{ID: id, Status: status, Body: body}
+}
+
+// insertedIDs reads the new rows' IDs from a POST response.
+func insertedIDs(body []byte) []interface{} {
+	var result struct {
+		Inserts []interface{} `json:"inserts"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber() // Keep large integer keys exact.
+	_ = decoder.Decode(&result)
+	return result.Inserts
+}
+
+// partialFailure reports whether a POST or bulk PATCH response lists errors for any of its rows.
+func partialFailure(method string, body []byte) bool {
+	switch method {
+	case http.MethodPost:
+		var post struct {
+			Errors []json.RawMessage `json:"errors"`
+		}
+		return json.Unmarshal(body, &post) == nil && len(post.Errors) > 0
+	case http.MethodPatch:
+	default:
+		return false
+	}
+
+	var rows []rowResult
+	if json.Unmarshal(body, &rows) != nil {
+		return false
+	}
+	for _, row := range rows {
+		if row.Status != http.StatusOK {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package bartlett
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	b := Bartlett{
+		DB:     db,
+		Driver: rowDriver{},
+		Tables: []Table{
+			{Name: `parents`, Writable: true},
+			{Name: `children`, Writable: true},
+		},
+		Users: dummyUserProvider,
+		Cache: &ResponseCache{},
+	}
+	handler := b.Handler()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO parents \(name\) VALUES \(\?\)`).WithArgs(`pat`).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectExec(`INSERT INTO children \(name,b\) VALUES \(\?,\?\)`).WithArgs(`kim`, `7`).
+		WillReturnResult(sqlmock.NewResult(8, 1))
+	mock.ExpectExec(`UPDATE parents SET a = \? WHERE id = \?`).WithArgs([]uint8(`x`), `7`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT \* FROM children WHERE b = \?`).WithArgs(`7`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`, `name`, `b`}).AddRow(8, `kim`, 7))
+	mock.ExpectCommit()
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, `/batch`, strings.NewReader(`[
+		{"id":"p","op":"insert","table":"parents","body":{"name":"pat"}},
+		{"op":"insert","table":"children","body":{"name":"kim","b":"${p}"}},
+		{"op":"update","table":"parents","key":"${p}","body":{"a":"x"}},
+		{"op":"select","table":"children","query":"b=eq.${p}"}
+	]`)))
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+	expected := `[{"id":"p","status":200,"body":{"errors":[],"inserts":[7]}},{"id":"1","status":200,"body":{"errors":[],"inserts":[8]}},{"id":"2","status":200},{"id":"3","status":200,"body":[{"b":7,"id":8,"name":"kim"}]}]`
+	if resp.Body.String() != expected {
+		t.Errorf(`Expected %s but got %s`, expected, resp.Body.String())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO parents \(name\) VALUES \(\?\)`).WithArgs(`pat`).
+		WillReturnResult(sqlmock.NewResult(9, 1))
+	mock.ExpectRollback()
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, `/batch`, strings.NewReader(`[
+		{"id":"p","op":"insert","table":"parents","body":{"name":"pat"}},
+		{"op":"update","table":"parents","key":"${q}","body":{"a":"x"}},
+		{"op":"delete","table":"parents","key":"${p}"}
+	]`)))
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf(`Expected "400" for an unknown reference but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+	if strings.Contains(resp.Body.String(), `"id":"2"`) {
+		t.Errorf(`Expected the batch to stop at the failed operation but got %s`, resp.Body.String())
+	}
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, `/batch`, nil))
+	if resp.Code != http.StatusMethodNotAllowed {
+		t.Errorf(`Expected "405" for GET on batch but got %d for status code`, resp.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
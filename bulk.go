@@ -0,0 +1,170 @@
+package bartlett
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/buger/jsonparser"
+	"net/http"
+)
+
+// A rowResult reports what became of one element of a bulk PATCH.
+type rowResult struct {
+	Key    interface{} `json:"key"`
+	Status int         `json:"status"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// handleBulkPatch applies each object in an array to the row named by its primary key, all in one transaction.
+// Every element runs as a PATCH to that row's item route would, including the URL's own filters and If-Match,
+// and gets its own result. Rows that fail are reported without undoing the others.
+func (b Bartlett) handleBulkPatch(t Table, w http.ResponseWriter, r *http.Request, body []byte, userID interface{}) {
+	key := t.primaryKey()
+	if key == `` {
+		writeError(w, http.StatusBadRequest, fmt.Errorf(`table %s has no single-column primary key`, t.Name))
+		return
+	}
+	if _, ok := itemID(r); ok {
+		writeError(w, http.StatusBadRequest, errors.New(`JSON data should be an object`))
+		return
+	}
+
+	ctx, cancel := b.context(t, r)
+	defer cancel()
+
+	tx, err := b.begin(ctx, r)
+	if err != nil {
+		queryError(ctx, w, err)
+		return
+	}
+	defer rollback(r, tx)
+
+	var (
+		results     = make([]rowResult, 0)
+		updated     int
+		abortErr    error
+		abortStatus int
+		captured    []*AuditRecord
+	)
+	_, err = jsonparser.ArrayEach(body, func(row []byte, dataType jsonparser.ValueType, offset int, err error) {
+		if abortErr != nil {
+			return // The whole PATCH is failing; the remaining rows would only be rolled back.
+		}
+		result := rowResult{Status: http.StatusOK}
+		defer func() {
+			results = append(results, result)
+		}()
+		fail := func(status int, err error) {
+			result.Status, result.Error = status, err.Error()
+		}
+
+		id, idType, _, err := jsonparser.Get(row, key)
+		if err != nil || idType == jsonparser.Null {
+			fail(http.StatusBadRequest, fmt.Errorf(`missing primary key %s`, key))
+			return
+		}
+		if idType == jsonparser.String {
+			str, _ := jsonparser.ParseString(id)
+			id = []byte(str)
+		}
+		result.Key = string(id)
+		if idType == jsonparser.Number {
+			result.Key = json.Number(id)
+		}
+
+		rowReq := withItem(r, string(id))
+		rowBody := t.withoutKey(row)
+		query, err := b.buildUpdate(t, rowReq, userID, rowBody)
+		if err == nil {
+			_, _, err = query.ToSql()
+		}
+		if err != nil {
+			fail(http.StatusBadRequest, err)
+			return
+		}
+
+		call := &Call{Operation: OpUpdate, Table: t, Request: rowReq, UserID: userID, Body: rowBody, Update: query}
+		abortErr = t.before(call, func(c *Call) (err error) {
+			c.Update, err = b.buildUpdate(t, rowReq, c.UserID, c.Body)
+			return err
+		})
+		if abortErr != nil {
+			abortStatus = http.StatusForbidden
+			return
+		}
+
+		matched, err := b.checkIfMatch(ctx, tx, call)
+		if err != nil {
+			fail(http.StatusInternalServerError, err)
+			return
+		}
+		if !matched {
+			fail(http.StatusPreconditionFailed, errPrecondition)
+			return
+		}
+		audit, err := b.captureBefore(ctx, tx, call)
+		if err != nil {
+			abortErr, abortStatus = err, http.StatusInternalServerError
+			return
+		}
+		res, err := call.Update.RunWith(tx).ExecContext(ctx)
+		if err != nil {
+			fail(http.StatusInternalServerError, err)
+			return
+		}
+
+		if status, err := call.written(tx, res); err != nil {
+			fail(status, err)
+			return
+		}
+
+		abortErr = t.after(call)
+		if abortErr == nil {
+			abortErr = b.captureAfter(ctx, tx, call, audit)
+		}
+		if abortErr != nil {
+			abortStatus = http.StatusInternalServerError
+			return
+		}
+
+		updated++
+		captured = append(captured, audit)
+	})
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf(`%s while parsing input`, err.Error()))
+		return
+	}
+
+	if abortErr != nil {
+		hookError(w, abortStatus, abortErr)
+		return
+	}
+
+	if ctx.Err() != nil { // Every update after the deadline fails the same way; report that instead of the row errors.
+		queryError(ctx, w, ctx.Err())
+		return
+	}
+
+	err = commit(r, tx, func() {
+		b.Cache.invalidate(t.Name)
+		for _, rec := range captured {
+			b.publish(rec)
+		}
+	})
+	if err != nil {
+		queryError(ctx, w, err)
+		return
+	}
+
+	out, err := json.Marshal(results)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if updated == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	_, _ = w.Write(out)
+}
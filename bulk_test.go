@@ -0,0 +1,107 @@
+package bartlett
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBulkPatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	b := Bartlett{
+		DB:     db,
+		Driver: dummyDriver{},
+		Tables: []Table{
+			{Name: `students`, Writable: true, UserID: `b`},
+		},
+		Users: dummyUserProvider,
+	}
+	handler := b.Handler()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE students SET name = \? WHERE id = \? AND b = \?`).
+		WithArgs([]uint8(`todd`), `1`, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE students SET name = \? WHERE id = \? AND b = \?`).
+		WithArgs([]uint8(`ned`), `2`, 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPatch, `/students`, strings.NewReader(
+		`[{"id":1,"name":"todd","b":7},{"id":2,"name":"ned"},{"name":"fred"}]`)))
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+	expected := `[{"key":1,"status":200},{"key":2,"status":404,"error":"row not found"},{"key":null,"status":400,"error":"missing primary key id"}]`
+	if resp.Body.String() != expected {
+		t.Errorf(`Expected %s but got %s`, expected, resp.Body.String())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPatch, `/students`, strings.NewReader(`[{"id":2}]`)))
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf(`Expected "400" when no row is updated but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPatch, `/students/1`, strings.NewReader(`[{"id":1,"name":"todd"}]`)))
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf(`Expected "400" for an array on an item route but got %d for status code`, resp.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestBulkPatchIfMatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	b := Bartlett{
+		DB:     db,
+		Driver: rowDriver{},
+		Tables: []Table{
+			{Name: `students`, Writable: true},
+		},
+		Users: dummyUserProvider,
+	}
+	handler := b.Handler()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM students WHERE id = \?`).
+		WithArgs(`1`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`, `name`}).AddRow(1, `todd`))
+	mock.ExpectExec(`UPDATE students SET name = \? WHERE id = \?`).
+		WithArgs([]uint8(`fred`), `1`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT \* FROM students WHERE id = \?`).
+		WithArgs(`2`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`, `name`}).AddRow(2, `changed`))
+	mock.ExpectCommit()
+	req := httptest.NewRequest(http.MethodPatch, `/students`, strings.NewReader(`[{"id":1,"name":"fred"},{"id":2,"name":"ned"}]`))
+	req.Header.Set(`If-Match`, hashETag([]byte(`{"id":1,"name":"todd"}`))+`, `+hashETag([]byte(`{"id":2,"name":"ned"}`)))
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+	expected := `[{"key":1,"status":200},{"key":2,"status":412,"error":"row has changed since it was read"}]`
+	if resp.Body.String() != expected {
+		t.Errorf(`Expected %s but got %s`, expected, resp.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
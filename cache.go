@@ -0,0 +1,251 @@
+package bartlett
+
+import (
+	"container/list"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/buger/jsonparser"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A ResponseCache keeps recent GET responses in memory so that repeated queries skip the database.
+// Entries are keyed by the compiled query and the user ID, and are dropped whenever a write through Bartlett changes
+// their table. Writes made any other way go unnoticed until TTL expires, so leave TTL short if there are any.
+// MaxEntries caps the number of responses kept, 1000 by default. The least recently used are dropped first.
+// The zero value is ready to use and keeps entries until they are invalidated or evicted.
+type ResponseCache struct {
+	MaxEntries int
+	TTL        time.Duration
+
+	mu          sync.Mutex
+	entries     map[string]*list.Element
+	recent      *list.List
+	generations map[string]uint64
+}
+
+// cachedResponse is a rendered GET response along with what conditional requests are checked against.
+type cachedResponse struct {
+	key          string
+	table        string
+	body         []byte
+	etag         string
+	lastModified time.Time
+	expires      time.Time
+}
+
+func (c *ResponseCache) get(key string) (cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return cachedResponse{}, false
+	}
+	resp := el.Value.(cachedResponse)
+	if !resp.expires.IsZero() && time.Now().After(resp.expires) {
+		c.remove(el)
+		return cachedResponse{}, false
+	}
+	c.recent.MoveToFront(el)
+
+	return resp, true
+}
+
+// generation counts the writes to a table. A response rendered before a write must not be stored after it.
+func (c *ResponseCache) generation(table string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generations[table]
+}
+
+// put stores resp unless its table has been written since generation was read.
+func (c *ResponseCache) put(resp cachedResponse, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generations[resp.table] != generation {
+		return
+	}
+	if c.entries == nil {
+		c.entries = make(map[string]*list.Element)
+		c.recent = list.New()
+	}
+	if c.TTL > 0 {
+		resp.expires = time.Now().Add(c.TTL)
+	}
+	if el, ok := c.entries[resp.key]; ok {
+		c.remove(el)
+	}
+	c.entries[resp.key] = c.recent.PushFront(resp)
+
+	max := c.MaxEntries
+	if max <= 0 {
+		max = 1000
+	}
+	for c.recent.Len() > max {
+		c.remove(c.recent.Back())
+	}
+}
+
+// invalidate drops every response from table. It is safe to call on a nil cache.
+func (c *ResponseCache) invalidate(table string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generations == nil {
+		c.generations = make(map[string]uint64)
+	}
+	c.generations[table]++
+	for _, el := range c.entries {
+		if el.Value.(cachedResponse).table == table {
+			c.remove(el)
+		}
+	}
+}
+
+func (c *ResponseCache) remove(el *list.Element) {
+	delete(c.entries, el.Value.(cachedResponse).key)
+	c.recent.Remove(el)
+}
+
+// cacheKey identifies a GET by its table, user and compiled query. Item routes render differently from the
+// equivalent filter, so they get their own keys.
+func cacheKey(c *Call, userID interface{}) (string, error) {
+	query, args, err := c.Select.ToSql()
+	if err != nil {
+		return ``, err
+	}
+	_, item := itemID(c.Request)
+
+	return fmt.Sprintf("%s\x00%v\x00%t\x00%s\x00%v", c.Table.Name, userID, item, query, args), nil
+}
+
+// errNoRow is returned when an item route's row does not exist.
+var errNoRow = errors.New(`row not found`)
+
+// renderGet collects the output of a SELECT, unwrapping the single row of an item route, and tags it for caching.
+// Item routes use the row's ETag, which is left out when `select` narrows a row of a table without a Version column.
+func (b Bartlett) renderGet(t Table, r *http.Request, rows *sql.Rows) (cachedResponse, error) {
+	resp := cachedResponse{table: t.Name}
+	var err error
+	resp.body, err = b.marshalRows(t, rows, t.jsonKeys(r))
+	if err != nil {
+		return resp, err
+	}
+
+	if _, ok := itemID(r); ok {
+		var item []byte
+		_, err = jsonparser.ArrayEach(resp.body, func(row []byte, dataType jsonparser.ValueType, offset int, err error) {
+			if item == nil {
+				item = row
+			}
+		})
+		if err != nil {
+			return resp, err
+		}
+		if item == nil {
+			return resp, errNoRow
+		}
+		resp.body = item
+		resp.etag, _ = t.rowETag(r, item)
+	} else {
+		resp.etag = hashETag(resp.body)
+	}
+	resp.lastModified = t.lastModified(resp.body)
+
+	return resp, nil
+}
+
+// writeCacheable sends a GET response with its validators, or 304 Not Modified if the client's copy is current.
+func writeCacheable(w http.ResponseWriter, r *http.Request, t Table, resp cachedResponse) {
+	if resp.etag != `` {
+		w.Header().Set(`ETag`, resp.etag)
+	}
+	if !resp.lastModified.IsZero() {
+		w.Header().Set(`Last-Modified`, resp.lastModified.UTC().Format(http.TimeFormat))
+	}
+	if t.CacheControl != `` {
+		w.Header().Set(`Cache-Control`, t.CacheControl)
+	}
+
+	if notModified(r, resp) {
+		w.Header().Del(`Content-Type`)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	_, _ = w.Write(resp.body)
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since in its absence, as RFC 7232 describes.
+func notModified(r *http.Request, resp cachedResponse) bool {
+	if fields := r.Header.Values(`If-None-Match`); len(fields) > 0 {
+		for _, field := range fields {
+			for _, tag := range strings.Split(field, `,`) {
+				tag = strings.TrimPrefix(strings.TrimSpace(tag), `W/`) // If-None-Match compares weakly.
+				if tag == `*` || (resp.etag != `` && tag == resp.etag) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get(`If-Modified-Since`))
+	if err != nil || resp.lastModified.IsZero() {
+		return false
+	}
+	return !resp.lastModified.Truncate(time.Second).After(since)
+}
+
+// lastModified finds the latest value of the LastModified column among the rendered rows.
+// Rows that leave the column out or hold a value it can't read as a time are skipped.
+func (t Table) lastModified(body []byte) time.Time {
+	var latest time.Time
+	if t.LastModified == `` {
+		return latest
+	}
+
+	check := func(row []byte) {
+		val, dataType, _, err := jsonparser.Get(row, t.LastModified)
+		if err != nil {
+			return
+		}
+		if at, ok := parseTimestamp(string(val), dataType); ok && at.After(latest) {
+			latest = at
+		}
+	}
+	if len(body) > 0 && body[0] == '[' {
+		_, _ = jsonparser.ArrayEach(body, func(row []byte, dataType jsonparser.ValueType, offset int, err error) {
+			check(row)
+		})
+	} else {
+		check(body)
+	}
+
+	return latest
+}
+
+var timestampLayouts = []string{time.RFC3339Nano, `2006-01-02 15:04:05.999999999`, `2006-01-02T15:04:05.999999999`, `2006-01-02`}
+
+// parseTimestamp reads the forms drivers commonly marshal times as: text timestamps and Unix seconds.
+func parseTimestamp(val string, dataType jsonparser.ValueType) (time.Time, bool) {
+	if dataType == jsonparser.Number {
+		secs, err := strconv.ParseInt(val, 10, 64)
+		return time.Unix(secs, 0), err == nil
+	}
+	for _, layout := range timestampLayouts {
+		if at, err := time.Parse(layout, val); err == nil {
+			return at, true
+		}
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,162 @@
+package bartlett
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConditionalGet(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	b := Bartlett{
+		DB:     db,
+		Driver: rowDriver{},
+		Tables: []Table{
+			{Name: `students`, LastModified: `updated_at`, CacheControl: `private, max-age=60`},
+		},
+		Users: dummyUserProvider,
+	}
+	handler := b.Handler()
+
+	rows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{`id`, `updated_at`}).
+			AddRow(1, `2021-03-04 05:06:07`).
+			AddRow(2, `2021-03-05 05:06:07`)
+	}
+	mock.ExpectQuery(`SELECT \* FROM students`).WillReturnRows(rows())
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, `/students`, nil))
+	etag := resp.Header().Get(`ETag`)
+	if etag != hashETag(resp.Body.Bytes()) {
+		t.Errorf(`Expected a hash of the output but got ETag %s`, etag)
+	}
+	if resp.Header().Get(`Last-Modified`) != `Fri, 05 Mar 2021 05:06:07 GMT` {
+		t.Errorf(`Expected the latest updated_at but got Last-Modified %s`, resp.Header().Get(`Last-Modified`))
+	}
+	if resp.Header().Get(`Cache-Control`) != `private, max-age=60` {
+		t.Errorf(`Expected the table's Cache-Control but got %s`, resp.Header().Get(`Cache-Control`))
+	}
+
+	mock.ExpectQuery(`SELECT \* FROM students`).WillReturnRows(rows())
+	req := httptest.NewRequest(http.MethodGet, `/students`, nil)
+	req.Header.Set(`If-None-Match`, `"abc", W/`+etag)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusNotModified || resp.Body.Len() != 0 {
+		t.Errorf(`Expected "304" with no body but got %d with body %s`, resp.Code, resp.Body.String())
+	}
+
+	mock.ExpectQuery(`SELECT \* FROM students`).WillReturnRows(rows())
+	req = httptest.NewRequest(http.MethodGet, `/students`, nil)
+	req.Header.Set(`If-Modified-Since`, `Fri, 05 Mar 2021 05:06:07 GMT`)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusNotModified {
+		t.Errorf(`Expected "304" but got %d for status code`, resp.Code)
+	}
+
+	mock.ExpectQuery(`SELECT \* FROM students`).WillReturnRows(rows())
+	req = httptest.NewRequest(http.MethodGet, `/students`, nil)
+	req.Header.Set(`If-None-Match`, `"abc"`)
+	req.Header.Set(`If-Modified-Since`, `Fri, 05 Mar 2021 05:06:07 GMT`)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected If-None-Match to override If-Modified-Since but got %d for status code`, resp.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	b := Bartlett{
+		DB:     db,
+		Driver: rowDriver{},
+		Tables: []Table{
+			{Name: `students`, Writable: true},
+		},
+		Users: dummyUserProvider,
+		Cache: &ResponseCache{},
+	}
+	handler := b.Handler()
+
+	mock.ExpectQuery(`SELECT \* FROM students WHERE id = \?`).
+		WithArgs(`1`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`, `name`}).AddRow(1, `todd`))
+	for i := 0; i < 2; i++ {
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, `/students?id=eq.1`, nil))
+		if resp.Body.String() != `[{"id":1,"name":"todd"}]` {
+			t.Errorf(`Expected todd on request %d but got %s`, i, resp.Body.String())
+		}
+	}
+
+	mock.ExpectQuery(`SELECT \* FROM students WHERE id = \?`).
+		WithArgs(`1`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`, `name`}).AddRow(1, `todd`))
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, `/students/1`, nil))
+	if resp.Body.String() != `{"id":1,"name":"todd"}` {
+		t.Errorf(`Expected the item route to be cached apart from the filter but got %s`, resp.Body.String())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE students SET name = \? WHERE id = \?`).
+		WithArgs([]uint8(`ned`), `1`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPatch, `/students?id=eq.1`, strings.NewReader(`{"name":"ned"}`)))
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	mock.ExpectQuery(`SELECT \* FROM students WHERE id = \?`).
+		WithArgs(`1`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`, `name`}).AddRow(1, `ned`))
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, `/students?id=eq.1`, nil))
+	if resp.Body.String() != `[{"id":1,"name":"ned"}]` {
+		t.Errorf(`Expected the write to invalidate the cache but got %s`, resp.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestResponseCacheEviction(t *testing.T) {
+	c := &ResponseCache{MaxEntries: 2}
+	for _, key := range []string{`a`, `b`, `c`} {
+		c.put(cachedResponse{key: key, table: `students`}, 0)
+	}
+	if _, ok := c.get(`a`); ok {
+		t.Errorf(`Expected the least recently used entry to be evicted`)
+	}
+	if _, ok := c.get(`c`); !ok {
+		t.Errorf(`Expected the newest entry to be kept`)
+	}
+
+	generation := c.generation(`students`)
+	c.invalidate(`students`)
+	c.put(cachedResponse{key: `d`, table: `students`}, generation)
+	if _, ok := c.get(`d`); ok {
+		t.Errorf(`Expected a response rendered before a write not to be stored`)
+	}
+	if _, ok := c.get(`c`); ok {
+		t.Errorf(`Expected invalidate to drop the table's entries`)
+	}
+}
@@ -0,0 +1,234 @@
+package bartlett
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	sqrl "github.com/Masterminds/squirrel"
+	"github.com/buger/jsonparser"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A ChangeEvent reports one row that was inserted, updated or deleted.
+// Key is the row's primary key. Row holds the deleted row for deletes and may be nil otherwise.
+type ChangeEvent struct {
+	Table     string
+	Operation Operation
+	Key       interface{}
+	Row       map[string]interface{}
+}
+
+// A ChangeSource delivers the change events for a table to subscribers.
+// The channel is closed when ctx ends or when the source gives up on the subscriber.
+// Bartlett's own writes reach any ChangeSource that is also a ChangePublisher, such as ChangeFeed.
+// Sources fed by database triggers or a binlog reader only need to implement Subscribe.
+type ChangeSource interface {
+	Subscribe(ctx context.Context, table string) (<-chan ChangeEvent, error)
+}
+
+// A ChangePublisher accepts the events produced by Bartlett's write handlers once their transaction commits.
+type ChangePublisher interface {
+	Publish(e ChangeEvent)
+}
+
+// ChangeFeed is an in-process ChangeSource and ChangePublisher. The zero value is ready to use.
+// Buffer is the number of events each subscriber may fall behind by, 16 if unset.
+// A subscriber that falls further behind is disconnected rather than allowed to hold up writes.
+// Subscribers check inserted and updated rows against their own queries, so each such event costs a SELECT for
+// every subscriber to the table.
+type ChangeFeed struct {
+	Buffer int
+	mu     sync.Mutex
+	subs   map[string]map[chan ChangeEvent]struct{}
+}
+
+// Subscribe registers for the events of table until ctx ends.
+func (f *ChangeFeed) Subscribe(ctx context.Context, table string) (<-chan ChangeEvent, error) {
+	size := f.Buffer
+	if size <= 0 {
+		size = 16
+	}
+	ch := make(chan ChangeEvent, size)
+
+	f.mu.Lock()
+	if f.subs == nil {
+		f.subs = make(map[string]map[chan ChangeEvent]struct{})
+	}
+	if f.subs[table] == nil {
+		f.subs[table] = make(map[chan ChangeEvent]struct{})
+	}
+	f.subs[table][ch] = struct{}{}
+	f.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		f.mu.Lock()
+		f.drop(table, ch)
+		f.mu.Unlock()
+	}()
+
+	return ch, nil
+}
+
+// Publish hands e to every subscriber of its table without waiting on any of them.
+func (f *ChangeFeed) Publish(e ChangeEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for ch := range f.subs[e.Table] {
+		select {
+		case ch <- e:
+		default:
+			f.drop(e.Table, ch) // Too slow; it can reconnect and reload.
+		}
+	}
+}
+
+// drop closes a subscriber's channel once. The caller must hold f.mu.
+func (f *ChangeFeed) drop(table string, ch chan ChangeEvent) {
+	if _, ok := f.subs[table][ch]; ok {
+		delete(f.subs[table], ch)
+		close(ch)
+	}
+}
+
+// publish turns the record of a committed write into change events.
+func (b Bartlett) publish(rec *AuditRecord) {
+	publisher, ok := b.Changes.(ChangePublisher)
+	if rec == nil || !ok {
+		return
+	}
+
+	for i, key := range rec.Keys {
+		e := ChangeEvent{Table: rec.Table, Operation: rec.Operation, Key: key}
+		if rec.Operation == OpDelete && i < len(rec.Before) {
+			e.Row = rec.Before[i]
+		}
+		publisher.Publish(e)
+	}
+}
+
+// wantsSubscription reports whether a GET asks for a change feed instead of a result set.
+func wantsSubscription(r *http.Request) bool {
+	_, ok := r.URL.Query()[`subscribe`]
+	return ok || strings.Contains(r.Header.Get(`Accept`), `text/event-stream`)
+}
+
+// handleSubscribe streams a table's changes to the client as server-sent events.
+// Inserted and updated rows are looked up with the subscriber's own query, so the filters, `select` and UserID
+// scoping of an ordinary GET decide what they see. Deleted rows are gone by then and are checked against UserID alone.
+// That is one SELECT per event per subscriber, run outside any transaction.
+func (b Bartlett) handleSubscribe(t Table, w http.ResponseWriter, r *http.Request) {
+	if b.Changes == nil {
+		writeError(w, http.StatusNotImplemented, errors.New(`change feed is not enabled`))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New(`streaming is not supported`))
+		return
+	}
+
+	userID, err := b.writeUser(t, r)
+	if err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+
+	events, err := b.Changes.Subscribe(r.Context(), t.Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set(`Content-Type`, `text/event-stream`)
+	w.Header().Set(`Cache-Control`, `no-cache`)
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			_, _ = fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			data, visible := b.changeData(t.Name, r, userID, e)
+			if !visible {
+				continue
+			}
+			_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Operation, data)
+			flusher.Flush()
+		}
+	}
+}
+
+// changeData renders e for one subscriber, reporting false when the subscriber may not see the row.
+// The table is looked up for every event, as it is for every request, since Refresh may have changed it since the
+// subscription began.
+func (b Bartlett) changeData(table string, r *http.Request, userID interface{}, e ChangeEvent) ([]byte, bool) {
+	t, ok := b.state.table(table)
+	if !ok {
+		return nil, false
+	}
+
+	event := struct {
+		Key interface{}     `json:"key"`
+		Row json.RawMessage `json:"row,omitempty"`
+	}{Key: e.Key}
+
+	if e.Operation == OpDelete {
+		if t.UserID != `` && (e.Row == nil || fmt.Sprint(e.Row[t.UserID]) != fmt.Sprint(userID)) {
+			return nil, false
+		}
+	} else {
+		key := t.primaryKey()
+		if key == `` {
+			return nil, false
+		}
+		query, err := b.buildSelect(t, r)
+		if err != nil {
+			return nil, false
+		}
+		call := &Call{Operation: OpSelect, Table: t, Request: r, UserID: userID, Select: query.Where(sqrl.Eq{t.quote(key): e.Key})}
+		if t.before(call, nil) != nil {
+			return nil, false
+		}
+
+		ctx, cancel := b.context(t, r)
+		defer cancel()
+		rows, err := call.Select.RunWith(b.DB).QueryContext(ctx)
+		if err != nil {
+			return nil, false
+		}
+		defer rows.Close()
+
+		body, err := b.marshalRows(t, rows, t.jsonKeys(r))
+		if err != nil {
+			return nil, false
+		}
+		_, _ = jsonparser.ArrayEach(body, func(row []byte, dataType jsonparser.ValueType, offset int, err error) {
+			if event.Row == nil {
+				event.Row = row
+			}
+		})
+		if event.Row == nil {
+			return nil, false // Filtered out, or already changed again.
+		}
+	}
+
+	data, err := json.Marshal(event)
+	return data, err == nil
+}
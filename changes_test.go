@@ -0,0 +1,152 @@
+package bartlett
+
+import (
+	"bufio"
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChangeFeed(t *testing.T) {
+	feed := &ChangeFeed{Buffer: 1}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fast, _ := feed.Subscribe(ctx, `students`)
+	slow, _ := feed.Subscribe(ctx, `students`)
+	other, _ := feed.Subscribe(ctx, `teachers`)
+
+	feed.Publish(ChangeEvent{Table: `students`, Operation: OpInsert, Key: 1})
+	if e := <-fast; e.Key != 1 {
+		t.Errorf(`Expected key 1 but got %+v`, e)
+	}
+
+	feed.Publish(ChangeEvent{Table: `students`, Operation: OpInsert, Key: 2}) // slow still holds key 1
+	if e := <-fast; e.Key != 2 {
+		t.Errorf(`Expected key 2 but got %+v`, e)
+	}
+	<-slow
+	if _, ok := <-slow; ok {
+		t.Error(`Expected slow subscriber to be disconnected`)
+	}
+
+	select {
+	case e := <-other:
+		t.Errorf(`Expected no events for teachers but got %+v`, e)
+	default:
+	}
+
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	if _, ok := <-fast; ok {
+		t.Error(`Expected subscription to end with its context`)
+	}
+}
+
+func TestDeletePublishes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	feed := &ChangeFeed{}
+	b := Bartlett{
+		DB:      db,
+		Driver:  dummyDriver{},
+		Tables:  []Table{{Name: `students`, Writable: true}},
+		Users:   dummyUserProvider,
+		Changes: feed,
+	}
+	routes := b.Routes()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, _ := feed.Subscribe(ctx, `students`)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM students WHERE id = \?`).
+		WithArgs(`4`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`, `name`}).AddRow(4, `todd`))
+	mock.ExpectExec(`DELETE FROM students WHERE id = \?`).
+		WithArgs(`4`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	resp := httptest.NewRecorder()
+	routes[0].Handler(resp, httptest.NewRequest(http.MethodDelete, `/students?id=eq.4`, nil))
+	if resp.Code != http.StatusOK {
+		t.Fatalf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	e := <-events
+	if e.Operation != OpDelete || e.Row[`name`] != `todd` {
+		t.Errorf(`Expected delete event for todd but got %+v`, e)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	feed := &ChangeFeed{}
+	b := Bartlett{
+		DB:      db,
+		Driver:  rowDriver{},
+		Tables:  []Table{{Name: `students`, UserID: `name`}},
+		Users:   func(_ *http.Request) (interface{}, error) { return `todd`, nil },
+		Changes: feed,
+	}
+	server := httptest.NewServer(b.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + `/students?subscribe&select=id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get(`Content-Type`) != `text/event-stream` {
+		t.Fatalf(`Expected an event stream but got %s`, resp.Header.Get(`Content-Type`))
+	}
+
+	mock.ExpectQuery(`SELECT id FROM students WHERE name = \? AND id = \?`).
+		WithArgs(`todd`, 7).
+		WillReturnRows(sqlmock.NewRows([]string{`id`}).AddRow(7))
+	feed.Publish(ChangeEvent{Table: `students`, Operation: OpInsert, Key: 7})
+	feed.Publish(ChangeEvent{Table: `students`, Operation: OpDelete, Key: 8, Row: map[string]interface{}{`id`: 8, `name`: `ned`}})
+	feed.Publish(ChangeEvent{Table: `students`, Operation: OpDelete, Key: 9, Row: map[string]interface{}{`id`: 9, `name`: `todd`}})
+
+	lines := bufio.NewScanner(resp.Body)
+	var got []string
+	for len(got) < 4 && lines.Scan() {
+		if lines.Text() != `` {
+			got = append(got, lines.Text())
+		}
+	}
+	want := []string{`event: insert`, `data: {"key":7,"row":{"id":7}}`, `event: delete`, `data: {"key":9}`}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected events\n%s\nbut got\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSubscribeDisabled(t *testing.T) {
+	b := Bartlett{Driver: dummyDriver{}, Tables: []Table{{Name: `students`}}, Users: dummyUserProvider}
+	routes := b.Routes()
+	req := httptest.NewRequest(http.MethodGet, `/students`, nil)
+	req.Header.Set(`Accept`, `text/event-stream`)
+	resp := httptest.NewRecorder()
+	routes[0].Handler(resp, req)
+	if resp.Code != http.StatusNotImplemented {
+		t.Errorf(`Expected "501" but got %d for status code`, resp.Code)
+	}
+}
@@ -10,7 +10,7 @@ import (
 )
 
 func (b Bartlett) buildDelete(t Table, r *http.Request) (sqrl.DeleteBuilder, error) {
-	query, err := deleteWhere(sqrl.Delete(t.Name), t, r)
+	query, err := deleteWhere(sqrl.Delete(t.sqlName()), t, r)
 	if err != nil {
 		return query, err
 	}
@@ -22,7 +22,7 @@ func (b Bartlett) buildDelete(t Table, r *http.Request) (sqrl.DeleteBuilder, err
 		if err != nil {
 			return query, err
 		}
-		query = query.Where(sqrl.Eq{t.UserID: userID})
+		query = query.Where(sqrl.Eq{t.quote(t.UserID): userID})
 	}
 
 	return query, nil
@@ -57,33 +57,14 @@ func deleteOrder(query sqrl.DeleteBuilder, t Table, r *http.Request) sqrl.Delete
 func deleteWhere(query sqrl.DeleteBuilder, t Table, r *http.Request) (sqrl.DeleteBuilder, error) {
 	var err error = nil
 	whereClauses := 0
-	i := 0
-	columns := make([]string, len(r.URL.Query()))
-	for k := range r.URL.Query() {
-		columns[i] = k
-		i++
+	if id, ok := itemID(r); ok {
+		query = query.Where(sqrl.Eq{t.quote(t.primaryKey()): id})
+		whereClauses++
 	}
-	columns = t.validReadColumns(columns)
-
-	for column, values := range r.URL.Query() {
-		if sliceContains(columns, column) {
-			for _, rawCond := range values {
-				parsedCond, val := parseSimpleWhereCond(rawCond)
-				var cond string
-				if parsedCond == `in` || parsedCond == `not.in` {
-					if parsedCond == `not.in` {
-						query = query.Where(sqrl.NotEq{column: whereIn(val)})
-					} else {
-						query = query.Where(sqrl.Eq{column: whereIn(val)})
-					}
-				} else {
-					cond = urlToWhereCond(column, parsedCond)
-					sqlCond, val := rectifyArg(cond, val)
-					query = query.Where(sqlCond, val)
-				}
-				whereClauses++
-			}
-		}
+	conds, _ := t.filters(r) // handleRoute has already refused filters that don't compile.
+	for _, cond := range conds {
+		query = query.Where(cond)
+		whereClauses++
 	}
 
 	if whereClauses == 0 {
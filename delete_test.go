@@ -19,7 +19,7 @@ func TestDelete(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	b := Bartlett{&sql.DB{}, dummyDriver{}, []Table{table}, dummyUserProvider}
+	b := Bartlett{DB: &sql.DB{}, Driver: dummyDriver{}, Tables: []Table{table}, Users: dummyUserProvider}
 
 	builder, err := b.buildDelete(table, req)
 	if err != nil {
@@ -45,7 +45,7 @@ func TestDeleteNeedsWhere(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	b := Bartlett{&sql.DB{}, dummyDriver{}, []Table{table}, dummyUserProvider}
+	b := Bartlett{DB: &sql.DB{}, Driver: dummyDriver{}, Tables: []Table{table}, Users: dummyUserProvider}
 
 	_, err = b.buildDelete(table, req)
 	if err == nil {
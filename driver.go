@@ -1,14 +1,57 @@
 package bartlett
 
 import (
+	"context"
 	"database/sql"
 	"net/http"
 )
 
 // The Driver interface contains database-specific code, which I'm trying to keep to a minimum.
 // Implement a column-identifying function and a result marshaling function for your database of choice.
+// MarshalResults passes each value through the Encoder that enc returns for its column, if there is one.
+// ProbeTables lists the tables in schema, or in the connection's default schema when it is blank.
+// Tables outside the default schema are named `schema.table`. Internal bookkeeping tables should be left out.
+// FilterSQL compiles the filter operators whose SQL varies between databases: `nseq`, `isdistinct`, `ilike`, and
+// `is.null`, `is.true`, `is.false` and `is.unknown`. The SQL has a `?` for the value, if the operator takes one.
+// Return false to use standard SQL, such as `column IS NOT DISTINCT FROM ?` for `nseq`.
+// JSONPath extracts the value at path, such as `$.address.city`, from a JSON column: as JSON text,
+// or as plain text if text is set. Paths only contain identifiers and array indexes.
+// QuoteIdent quotes a single table, schema or column name, so that reserved words and names with spaces work.
 type Driver interface {
-	GetColumns(db *sql.DB, t Table) ([]string, error)
-	MarshalResults(rows *sql.Rows, w http.ResponseWriter) error
-	ProbeTables(db *sql.DB) []Table
+	GetColumns(ctx context.Context, db *sql.DB, t Table) ([]Column, error)
+	MarshalResults(rows *sql.Rows, w http.ResponseWriter, enc Encoding) error
+	ProbeTables(ctx context.Context, db *sql.DB, schema string) ([]Table, error)
+	FilterSQL(op, column string) (string, bool)
+	JSONPath(column, path string, text bool) string
+	QuoteIdent(name string) string
+}
+
+// A Column describes one column of a table as reported by the Driver.
+// Type is the database's own name for the column type. Affinity is the storage class SQLite gives that type,
+// such as INTEGER or TEXT, and is left blank by databases without type affinity.
+// NotNull and HasDefault decide whether inserts must supply the column. Auto-increment columns count as having a default.
+// Default is an SQL expression that evaluates to the column's default, which PUT stores in columns it leaves out.
+// Length is the maximum length of a string column and Enum lists the values an enumerated column accepts.
+// Generated marks a column computed from others, which API users can't write.
+// References is the column's foreign key, which lets a POST to the referenced table carry rows for this one.
+// Zero values mean unknown, and leave writes to the column unchecked.
+type Column struct {
+	Name       string
+	Type       string
+	Affinity   string
+	PrimaryKey bool
+	NotNull    bool
+	HasDefault bool
+	Default    string
+	Length     int
+	Enum       []string
+	Generated  bool
+	References *ForeignKey
+}
+
+// A ForeignKey names the column that another column refers to. Column is blank for the table's primary key.
+// Tables outside the default schema are named `schema.table`, as in ProbeTables.
+type ForeignKey struct {
+	Table  string
+	Column string
 }
@@ -0,0 +1,158 @@
+package bartlett
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An Encoder converts a value read from the database into the value that is marshaled as JSON.
+// It receives the value as the Driver scanned it, such as a string, []byte, integer, float, bool or time.Time,
+// or nil for NULL.
+type Encoder func(v interface{}) (interface{}, error)
+
+// Encoders lets applications choose how the values of particular SQL types or table columns appear in JSON.
+// A column's Encoder wins over its type's. Types are matched without their length or precision,
+// and regardless of case, so `DECIMAL` covers `decimal(10,2)`.
+// The zero value is ready to use, and Encoders may be registered while requests are being served.
+type Encoders struct {
+	mu      sync.RWMutex
+	types   map[string]Encoder
+	columns map[string]Encoder
+}
+
+// RegisterType encodes every column of sqlType with enc.
+func (e *Encoders) RegisterType(sqlType string, enc Encoder) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.types == nil {
+		e.types = make(map[string]Encoder)
+	}
+	e.types[baseType(sqlType)] = enc
+}
+
+// RegisterColumn encodes column of table with enc.
+func (e *Encoders) RegisterColumn(table, column string, enc Encoder) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.columns == nil {
+		e.columns = make(map[string]Encoder)
+	}
+	e.columns[table+`.`+column] = enc
+}
+
+func (e *Encoders) lookup(table, column, sqlType string) Encoder {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if enc, ok := e.columns[table+`.`+column]; ok {
+		return enc
+	}
+	return e.types[baseType(sqlType)]
+}
+
+// baseType strips the length, precision and case from a type, eg `decimal(10,2)` becomes `DECIMAL`.
+func baseType(sqlType string) string {
+	if i := strings.Index(sqlType, `(`); i >= 0 {
+		sqlType = sqlType[:i]
+	}
+	return strings.ToUpper(strings.TrimSpace(sqlType))
+}
+
+// An Encoding is what a Driver's MarshalResults needs to find the Encoders for a result set of Table.
+// The zero value encodes nothing.
+type Encoding struct {
+	Table    string
+	Encoders *Encoders
+}
+
+// Encoder returns the Encoder registered for a column of the result set, or nil to marshal its value as the Driver would.
+func (e Encoding) Encoder(column, sqlType string) Encoder {
+	if e.Encoders == nil {
+		return nil
+	}
+	return e.Encoders.lookup(e.Table, column, sqlType)
+}
+
+func (b Bartlett) encoding(t Table) Encoding {
+	return Encoding{Table: t.Name, Encoders: b.Encoders}
+}
+
+// EncodeNumber emits numbers that the database returns as text, such as DECIMAL, as JSON numbers without rounding.
+func EncodeNumber(v interface{}) (interface{}, error) {
+	var s string
+	switch val := v.(type) {
+	case string:
+		s = val
+	case []byte:
+		s = string(val)
+	default:
+		return v, nil
+	}
+	if _, err := strconv.ParseFloat(s, 64); err != nil || !json.Valid([]byte(s)) {
+		return nil, fmt.Errorf(`%q is not a number`, s)
+	}
+	return json.Number(s), nil
+}
+
+// sqlTimes are the layouts in which databases return dates and times as text.
+var sqlTimes = []string{`2006-01-02 15:04:05.999999999`, time.RFC3339Nano, `2006-01-02T15:04:05.999999999`}
+
+// EncodeRFC3339 emits dates and times as RFC 3339 strings in UTC. A date without a time is emitted as a full-date,
+// eg `2006-01-02`. Timestamps without a zone are taken to be in UTC.
+func EncodeRFC3339(v interface{}) (interface{}, error) {
+	var s string
+	switch val := v.(type) {
+	case time.Time:
+		return val.UTC().Format(time.RFC3339Nano), nil
+	case string:
+		s = val
+	case []byte:
+		s = string(val)
+	default:
+		return v, nil
+	}
+
+	if date, err := time.Parse(`2006-01-02`, s); err == nil {
+		return date.Format(`2006-01-02`), nil
+	}
+	for _, layout := range sqlTimes {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC().Format(time.RFC3339Nano), nil
+		}
+	}
+	return nil, fmt.Errorf(`%q is not a date or time`, s)
+}
+
+// EncodeHex emits binary values as hexadecimal strings.
+func EncodeHex(v interface{}) (interface{}, error) {
+	switch val := v.(type) {
+	case []byte:
+		return hex.EncodeToString(val), nil
+	case string:
+		return hex.EncodeToString([]byte(val)), nil
+	default:
+		return v, nil
+	}
+}
+
+// EncodeDataURL returns an Encoder that emits binary values as `data:` URLs of the given media type, eg `image/png`.
+func EncodeDataURL(mediaType string) Encoder {
+	return func(v interface{}) (interface{}, error) {
+		switch val := v.(type) {
+		case []byte:
+			return `data:` + mediaType + `;base64,` + base64.StdEncoding.EncodeToString(val), nil
+		case string:
+			return `data:` + mediaType + `;base64,` + base64.StdEncoding.EncodeToString([]byte(val)), nil
+		default:
+			return v, nil
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package bartlett
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestEncoders(t *testing.T) {
+	var encoders Encoders
+	if (Encoding{Table: `students`}).Encoder(`grade`, `DECIMAL`) != nil {
+		t.Errorf(`Expected no encoder without a registry`)
+	}
+
+	encoders.RegisterType(`decimal`, EncodeNumber)
+	encoders.RegisterColumn(`students`, `photo`, EncodeDataURL(`image/png`))
+	enc := Encoding{Table: `students`, Encoders: &encoders}
+
+	if enc.Encoder(`grade`, `DECIMAL(10,2)`) == nil {
+		t.Errorf(`Expected DECIMAL(10,2) to use the DECIMAL encoder`)
+	}
+	if enc.Encoder(`photo`, `BLOB`) == nil || enc.Encoder(`name`, `BLOB`) != nil {
+		t.Errorf(`Expected only the photo column to have a BLOB encoder`)
+	}
+	if (Encoding{Table: `teachers`, Encoders: &encoders}).Encoder(`photo`, `BLOB`) != nil {
+		t.Errorf(`Expected column encoders to apply to their own table only`)
+	}
+
+	photo, _ := enc.Encoder(`photo`, `BLOB`)([]byte{1, 2})
+	if photo != `data:image/png;base64,AQI=` {
+		t.Errorf(`Expected a data URL but got %v`, photo)
+	}
+}
+
+func TestBuiltinEncoders(t *testing.T) {
+	grade, err := EncodeNumber(`91.50`)
+	out, _ := json.Marshal(grade)
+	if err != nil || string(out) != `91.50` {
+		t.Errorf(`Expected 91.50 but got %s`, out)
+	}
+	if _, err = EncodeNumber(`NaN`); err == nil {
+		t.Errorf(`Expected NaN to be refused`)
+	}
+	if v, _ := EncodeNumber(nil); v != nil {
+		t.Errorf(`Expected NULL to stay nil but got %v`, v)
+	}
+
+	for in, expected := range map[interface{}]string{
+		`2020-01-02 03:04:05`: `2020-01-02T03:04:05Z`,
+		`2020-01-02`:          `2020-01-02`,
+		time.Date(2020, 1, 2, 4, 4, 5, 0, time.FixedZone(`CET`, 3600)): `2020-01-02T03:04:05Z`,
+	} {
+		v, err := EncodeRFC3339(in)
+		if err != nil || v != expected {
+			t.Errorf(`Expected %s for %v but got %v`, expected, in, v)
+		}
+	}
+	if _, err = EncodeRFC3339(`yesterday`); err == nil {
+		t.Errorf(`Expected a non-date to be refused`)
+	}
+
+	if v, _ := EncodeHex([]byte{0xca, 0xfe}); v != `cafe` {
+		t.Errorf(`Expected cafe but got %v`, v)
+	}
+}
@@ -0,0 +1,145 @@
+package bartlett
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	sqrl "github.com/Masterminds/squirrel"
+	"github.com/buger/jsonparser"
+	"net/http"
+	"strings"
+)
+
+// A VersionSpec names an integer column that counts the edits to its row, so clients can tell whether a row has been
+// edited since they read it.
+// Bartlett writes 1 to the column on INSERT and adds one on every UPDATE, ignoring any value API users send for it.
+// Updated-at timestamps make poor versions, since two edits within the clock's precision look the same;
+// tables that only have one should rely on the default row hash instead.
+type VersionSpec struct {
+	Name string
+}
+
+// nextVersion is the value an UPDATE stores in the version column, given the column's quoted name.
+func nextVersion(column string) sqrl.Sqlizer {
+	return sqrl.Expr(`COALESCE(` + column + `, 0) + 1`)
+}
+
+// rowETag identifies the revision of a single row as marshaled by the Driver.
+// Tables with a Version column use its value; others use a hash of the whole row, so a row narrowed by `select` has none.
+func (t Table) rowETag(r *http.Request, row []byte) (string, bool) {
+	if t.Version.Name != `` {
+		val, _, _, err := jsonparser.Get(row, t.Version.Name)
+		if err != nil {
+			return ``, false // The version column was left out by `select`.
+		}
+		return `"` + base64.RawURLEncoding.EncodeToString(val) + `"`, true
+	}
+
+	if len(r.URL.Query()[`select`]) > 0 {
+		return ``, false
+	}
+	return hashETag(row), true
+}
+
+func hashETag(row []byte) string {
+	sum := sha256.Sum256(row)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// ifMatch parses the If-Match header into its entity tags. Weak tags never match, as RFC 7232 requires.
+func ifMatch(r *http.Request) (tags []string, any bool, present bool) {
+	for _, field := range r.Header.Values(`If-Match`) {
+		present = true
+		for _, tag := range strings.Split(field, `,`) {
+			tag = strings.TrimSpace(tag)
+			if tag == `*` {
+				any = true
+			} else if strings.HasPrefix(tag, `"`) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	return tags, any, present
+}
+
+// tagVersions decodes the version column values that If-Match tags carry.
+func tagVersions(tags []string) []string {
+	versions := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		val, err := base64.RawURLEncoding.DecodeString(strings.Trim(tag, `"`))
+		if err == nil {
+			versions = append(versions, string(val))
+		}
+	}
+
+	return versions
+}
+
+// errPrecondition is returned when If-Match names none of the current row versions.
+var errPrecondition = errors.New(`row has changed since it was read`)
+
+// checkIfMatch compares each row an UPDATE or DELETE is about to touch with If-Match, and reports whether every one
+// of them matches one of its tags. The rows are read inside the write's transaction.
+// For tables with a Version column, the write is also restricted to the listed versions, so that a row changed
+// since it was read is caught by finishWrite.
+func (b Bartlett) checkIfMatch(ctx context.Context, tx *sql.Tx, c *Call) (bool, error) {
+	tags, any, present := ifMatch(c.Request)
+	if !present || any {
+		return true, nil
+	}
+	if c.Table.Version.Name != `` {
+		return c.checkVersions(ctx, tx, tagVersions(tags))
+	}
+
+	rows, err := c.targetRows(`*`).RunWith(tx).QueryContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	body, err := b.marshalRows(c.Table, rows, c.Table.jsonColumns())
+	if err != nil {
+		return false, err
+	}
+
+	found, matched := 0, 0
+	_, err = jsonparser.ArrayEach(body, func(row []byte, dataType jsonparser.ValueType, offset int, err error) {
+		found++
+		if sliceContains(tags, hashETag(row)) {
+			matched++
+		}
+	})
+
+	return found > 0 && found == matched, err
+}
+
+// checkVersions compares the version of each row the Call targets with versions, and guards the write with them.
+func (c *Call) checkVersions(ctx context.Context, tx *sql.Tx, versions []string) (bool, error) {
+	column := c.Table.quote(c.Table.Version.Name)
+	rows, err := scanRows(ctx, tx, c.targetRows(column))
+	if err != nil {
+		return false, err
+	}
+	for _, row := range rows {
+		if !sliceContains(versions, fmt.Sprint(row[c.Table.Version.Name])) {
+			return false, nil
+		}
+	}
+	if len(rows) == 0 {
+		return false, nil
+	}
+
+	in := make([]interface{}, len(versions))
+	for i, v := range versions {
+		in[i] = v
+	}
+	c.guard(sqrl.Eq{column: in})
+	c.versioned = int64(len(rows))
+
+	return true, nil
+}
@@ -0,0 +1,228 @@
+package bartlett
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVersionETag(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	b := Bartlett{
+		DB:     db,
+		Driver: rowDriver{},
+		Tables: []Table{
+			{Name: `students`, Writable: true, Version: VersionSpec{Name: `version`}},
+		},
+		Users: dummyUserProvider,
+	}
+	handler := b.Handler()
+
+	mock.ExpectQuery(`SELECT \* FROM students WHERE id = \?`).
+		WithArgs(`42`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`, `version`}).AddRow(42, 3))
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, `/students/42`, nil))
+	etag := resp.Header().Get(`ETag`)
+	if etag != `"Mw"` {
+		t.Errorf(`Expected ETag "Mw" but got %s`, etag)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT version FROM students WHERE id = \?`).
+		WithArgs(`42`).
+		WillReturnRows(sqlmock.NewRows([]string{`version`}).AddRow(4))
+	mock.ExpectRollback()
+	req := httptest.NewRequest(http.MethodPatch, `/students/42`, strings.NewReader(`{"name":"ned","version":9}`))
+	req.Header.Set(`If-Match`, etag)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusPreconditionFailed {
+		t.Errorf(`Expected "412" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT version FROM students WHERE id = \?`).
+		WithArgs(`42`).
+		WillReturnRows(sqlmock.NewRows([]string{`version`}).AddRow(3))
+	mock.ExpectExec(`UPDATE students SET name = \?, version = COALESCE\(version, 0\) \+ 1 WHERE id = \? AND version IN \(\?\)`).
+		WithArgs([]uint8(`ned`), `42`, `3`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	req = httptest.NewRequest(http.MethodPatch, `/students/42`, strings.NewReader(`{"name":"ned"}`))
+	req.Header.Set(`If-Match`, etag)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusPreconditionFailed {
+		t.Errorf(`Expected "412" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT version FROM students WHERE id = \?`).
+		WithArgs(`42`).
+		WillReturnRows(sqlmock.NewRows([]string{`version`}).AddRow(3))
+	mock.ExpectExec(`DELETE FROM students WHERE id = \? AND version IN \(\?,\?\)`).
+		WithArgs(`42`, `2`, `3`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	req = httptest.NewRequest(http.MethodDelete, `/students/42`, nil)
+	req.Header.Set(`If-Match`, `W/"NQ", "Mg", `+etag)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO students \(name,version\) VALUES \(\?,\?\)`).
+		WithArgs(`todd`, 1).
+		WillReturnResult(sqlmock.NewResult(43, 1))
+	mock.ExpectCommit()
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, `/students`, strings.NewReader(`{"name":"todd","version":7}`)))
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestVersionETagCollection(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	b := Bartlett{
+		DB:     db,
+		Driver: rowDriver{},
+		Tables: []Table{
+			{Name: `students`, Writable: true, Version: VersionSpec{Name: `version`}},
+		},
+		Users: dummyUserProvider,
+	}
+	handler := b.Handler()
+	patch := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, `/students?id=in.(1,2)`, strings.NewReader(`{"name":"ned"}`))
+		req.Header.Set(`If-Match`, `"Mw", "NA"`)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// One row is current and the other stale.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT version FROM students WHERE id IN \(\?,\?\)`).
+		WithArgs(`1`, `2`).
+		WillReturnRows(sqlmock.NewRows([]string{`version`}).AddRow(3).AddRow(5))
+	mock.ExpectRollback()
+	if resp := patch(); resp.Code != http.StatusPreconditionFailed {
+		t.Errorf(`Expected "412" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	// Both rows are current when read, but one changes before the UPDATE.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT version FROM students WHERE id IN \(\?,\?\)`).
+		WithArgs(`1`, `2`).
+		WillReturnRows(sqlmock.NewRows([]string{`version`}).AddRow(3).AddRow(4))
+	mock.ExpectExec(`UPDATE students SET name = \?, version = COALESCE\(version, 0\) \+ 1 WHERE id IN \(\?,\?\) AND version IN \(\?,\?\)`).
+		WithArgs([]uint8(`ned`), `1`, `2`, `3`, `4`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+	if resp := patch(); resp.Code != http.StatusPreconditionFailed {
+		t.Errorf(`Expected "412" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT version FROM students WHERE id IN \(\?,\?\)`).
+		WithArgs(`1`, `2`).
+		WillReturnRows(sqlmock.NewRows([]string{`version`}).AddRow(3).AddRow(4))
+	mock.ExpectExec(`UPDATE students SET name = \?, version = COALESCE\(version, 0\) \+ 1 WHERE id IN \(\?,\?\) AND version IN \(\?,\?\)`).
+		WithArgs([]uint8(`ned`), `1`, `2`, `3`, `4`).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	if resp := patch(); resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestHashETag(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	b := Bartlett{
+		DB:     db,
+		Driver: rowDriver{},
+		Tables: []Table{
+			{Name: `students`, Writable: true},
+		},
+		Users: dummyUserProvider,
+	}
+	handler := b.Handler()
+
+	mock.ExpectQuery(`SELECT \* FROM students WHERE id = \?`).
+		WithArgs(`42`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`, `name`}).AddRow(42, `todd`))
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, `/students/42`, nil))
+	etag := resp.Header().Get(`ETag`)
+	if etag != hashETag([]byte(`{"id":42,"name":"todd"}`)) {
+		t.Errorf(`Expected a hash of the row but got ETag %s`, etag)
+	}
+
+	mock.ExpectQuery(`SELECT name FROM students WHERE id = \?`).
+		WithArgs(`42`).
+		WillReturnRows(sqlmock.NewRows([]string{`name`}).AddRow(`todd`))
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, `/students/42?select=name`, nil))
+	if resp.Header().Get(`ETag`) != `` {
+		t.Errorf(`Expected no ETag for part of a row but got %s`, resp.Header().Get(`ETag`))
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM students WHERE id = \?`).
+		WithArgs(`42`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`, `name`}).AddRow(42, `ned`))
+	mock.ExpectRollback()
+	req := httptest.NewRequest(http.MethodPatch, `/students/42`, strings.NewReader(`{"name":"fred"}`))
+	req.Header.Set(`If-Match`, etag)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusPreconditionFailed {
+		t.Errorf(`Expected "412" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM students WHERE id = \?`).
+		WithArgs(`42`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`, `name`}).AddRow(42, `todd`))
+	mock.ExpectExec(`UPDATE students SET name = \? WHERE id = \?`).
+		WithArgs([]uint8(`fred`), `42`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	req = httptest.NewRequest(http.MethodPatch, `/students/42`, strings.NewReader(`{"name":"fred"}`))
+	req.Header.Set(`If-Match`, etag)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
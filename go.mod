@@ -7,11 +7,12 @@ require (
 	github.com/Masterminds/squirrel v1.5.2
 	github.com/buger/jsonparser v1.1.1
 	github.com/go-sql-driver/mysql v1.6.0
+	github.com/gorilla/websocket v1.5.0
+	github.com/lann/builder v0.0.0-20180802200727-47ae307949d0
 	github.com/mattn/go-sqlite3 v1.14.11
 )
 
 require (
-	github.com/lann/builder v0.0.0-20180802200727-47ae307949d0 // indirect
 	github.com/lann/ps v0.0.0-20150810152359-62de8c46ede0 // indirect
 	github.com/stretchr/testify v1.3.0 // indirect
 )
@@ -0,0 +1,193 @@
+package bartlett
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	sqrl "github.com/Masterminds/squirrel"
+	"github.com/lann/builder"
+	"net/http"
+	"strconv"
+)
+
+// An Operation identifies the kind of statement a request runs against a table.
+type Operation string
+
+// These are the operations a Hook can be attached to.
+const (
+	OpSelect Operation = `select`
+	OpInsert Operation = `insert`
+	OpUpdate Operation = `update`
+	OpDelete Operation = `delete`
+)
+
+// A Hook runs application code around the operations of a table.
+// Operations lists the operations it applies to; leave it empty to run on every operation.
+// Before runs once the request has been parsed and the query built, but before anything is executed.
+// It may change the query, change Body, or return an error to reject the request.
+// After runs once the query has executed. For writes it runs inside the transaction, so an error rolls it back.
+// Either function may be nil.
+type Hook struct {
+	Operations []Operation
+	Before     func(c *Call) error
+	After      func(c *Call) error
+}
+
+// A Call describes one operation as it passes through a table's hooks.
+// Only the builder matching Operation is set. Replace it to change the query that will run.
+// Body holds the JSON object being written: the whole object for an update and one row at a time for inserts.
+// Replacing Body in a Before hook rebuilds the query from the new Body, discarding changes that hook made to the builder.
+// Tx, RowsAffected and IDs are filled in for After hooks on writes. IDs holds the ID of an inserted row,
+// or the primary keys of the rows an update or delete targeted, as read just before it ran.
+// Tables without a single-column primary key get no IDs for updates and deletes.
+// A delete from a table with SoftDelete is an UPDATE, so it sets Update rather than Delete.
+type Call struct {
+	Operation Operation
+	Table     Table
+	Request   *http.Request
+	UserID    interface{}
+	Body      []byte
+
+	Select sqrl.SelectBuilder
+	Insert sqrl.InsertBuilder
+	Update sqrl.UpdateBuilder
+	Delete sqrl.DeleteBuilder
+
+	Tx           *sql.Tx
+	RowsAffected int64
+	IDs          []interface{}
+
+	versioned int64 // The rows If-Match found at a listed version, all of which the write must change.
+}
+
+// A StatusError lets a hook choose the status code of the response when it fails a request.
+// Other errors from Before hooks are reported as 403 Forbidden and errors from After hooks as 500.
+type StatusError struct {
+	Status int
+	Err    error
+}
+
+func (e StatusError) Error() string {
+	return e.Err.Error()
+}
+
+func (e StatusError) Unwrap() error {
+	return e.Err
+}
+
+// hookError picks the status code for an error returned by a hook.
+func hookError(w http.ResponseWriter, fallback int, err error) {
+	var statusErr StatusError
+	if errors.As(err, &statusErr) {
+		writeError(w, statusErr.Status, err)
+		return
+	}
+
+	writeError(w, fallback, err)
+}
+
+func (h Hook) appliesTo(op Operation) bool {
+	if len(h.Operations) == 0 {
+		return true
+	}
+	for _, o := range h.Operations {
+		if o == op {
+			return true
+		}
+	}
+
+	return false
+}
+
+// before runs the table's Before hooks in order. rebuild recreates the query when a hook replaces c.Body.
+func (t Table) before(c *Call, rebuild func(c *Call) error) error {
+	for _, h := range t.Hooks {
+		if h.Before == nil || !h.appliesTo(c.Operation) {
+			continue
+		}
+		body := c.Body
+		err := h.Before(c)
+		if err != nil {
+			return err
+		}
+		if rebuild != nil && !bytes.Equal(body, c.Body) {
+			err = rebuild(c)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// after runs the table's After hooks in order, stopping at the first error.
+func (t Table) after(c *Call) error {
+	for _, h := range t.Hooks {
+		if h.After == nil || !h.appliesTo(c.Operation) {
+			continue
+		}
+		err := h.After(c)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hasAfter reports whether any After hook runs for op.
+func (t Table) hasAfter(op Operation) bool {
+	for _, h := range t.Hooks {
+		if h.After != nil && h.appliesTo(op) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// statement returns the builder for the write the Call describes.
+func (c *Call) statement() sqrl.Sqlizer {
+	switch {
+	case c.Operation == OpInsert:
+		return c.Insert
+	case c.Operation == OpUpdate, c.Operation == OpDelete && c.Table.SoftDelete.Name != ``:
+		return c.Update
+	case c.Operation == OpDelete:
+		return c.Delete
+	}
+
+	return c.Select
+}
+
+// targetRows selects the rows the Call's UPDATE or DELETE applies to, using the statement's own WHERE, ORDER BY and
+// LIMIT, so that conditions added by Before hooks count too.
+func (c *Call) targetRows(columns string) sqrl.SelectBuilder {
+	query := sqrl.Select(columns).From(c.Table.sqlName())
+	stmt := c.statement()
+	if parts, ok := builder.Get(stmt, `WhereParts`); ok {
+		for _, part := range parts.([]sqrl.Sqlizer) {
+			query = query.Where(part)
+		}
+	}
+	if orders, ok := builder.Get(stmt, `OrderBys`); ok {
+		query = query.OrderBy(orders.([]string)...)
+	}
+	if limit, ok := builder.Get(stmt, `Limit`); ok {
+		if n, err := strconv.ParseUint(limit.(string), 10, 64); err == nil {
+			query = query.Limit(n)
+		}
+	}
+
+	return query
+}
+
+// guard adds cond to the WHERE of the Call's UPDATE or DELETE.
+func (c *Call) guard(cond sqrl.Sqlizer) {
+	if c.Operation == OpUpdate || c.Table.SoftDelete.Name != `` {
+		c.Update = c.Update.Where(cond)
+	} else {
+		c.Delete = c.Delete.Where(cond)
+	}
+}
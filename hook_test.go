@@ -0,0 +1,279 @@
+package bartlett
+
+import (
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	sqrl "github.com/Masterminds/squirrel"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHookBeforeSelect(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	b := Bartlett{
+		DB:     db,
+		Driver: dummyDriver{},
+		Tables: []Table{
+			{
+				Name: `students`,
+				Hooks: []Hook{
+					{
+						Operations: []Operation{OpSelect},
+						Before: func(c *Call) error {
+							c.Select = c.Select.Where(`archived = ?`, false)
+							return nil
+						},
+					},
+					{
+						Operations: []Operation{OpDelete},
+						Before: func(c *Call) error {
+							return errors.New(`should not run for select`)
+						},
+					},
+				},
+			},
+		},
+		Users: dummyUserProvider,
+	}
+
+	routes := b.Routes()
+
+	mock.ExpectQuery(`SELECT \* FROM students WHERE archived = \?`).
+		WithArgs(false).
+		WillReturnRows(sqlmock.NewRows([]string{`id`}))
+	resp := httptest.NewRecorder()
+	routes[0].Handler(resp, httptest.NewRequest(http.MethodGet, `/students`, nil))
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestHookRejects(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	b := Bartlett{
+		DB:     db,
+		Driver: dummyDriver{},
+		Tables: []Table{
+			{
+				Name:     `students`,
+				Writable: true,
+				Hooks: []Hook{
+					{
+						Before: func(c *Call) error {
+							if c.Operation == OpDelete {
+								return StatusError{Status: http.StatusConflict, Err: errors.New(`students are never deleted`)}
+							}
+							return errors.New(`no updates today`)
+						},
+					},
+				},
+			},
+		},
+		Users: dummyUserProvider,
+	}
+
+	routes := b.Routes()
+
+	resp := httptest.NewRecorder()
+	routes[0].Handler(resp, httptest.NewRequest(http.MethodDelete, `/students?id=eq.1`, nil))
+	if resp.Code != http.StatusConflict {
+		t.Errorf(`Expected "409" but got %d for status code`, resp.Code)
+	}
+
+	resp = httptest.NewRecorder()
+	routes[0].Handler(resp, httptest.NewRequest(http.MethodPatch, `/students?id=eq.1`, strings.NewReader(`{"name":"todd"}`)))
+	if resp.Code != http.StatusForbidden {
+		t.Errorf(`Expected "403" but got %d for status code`, resp.Code)
+	}
+	if !strings.Contains(resp.Body.String(), `no updates today`) {
+		t.Errorf(`Expected hook error in body but got %s`, resp.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestHookBeforeBody(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	b := Bartlett{
+		DB:     db,
+		Driver: dummyDriver{},
+		Tables: []Table{
+			{
+				Name:     `students`,
+				Writable: true,
+				Hooks: []Hook{
+					{
+						Operations: []Operation{OpUpdate},
+						Before: func(c *Call) error {
+							c.Body = []byte(`{"name":"TODD"}`)
+							return nil
+						},
+					},
+				},
+			},
+		},
+		Users: dummyUserProvider,
+	}
+
+	routes := b.Routes()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE students SET name = \? WHERE id = \?`).
+		WithArgs([]uint8(`TODD`), `1`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	resp := httptest.NewRecorder()
+	routes[0].Handler(resp, httptest.NewRequest(http.MethodPatch, `/students?id=eq.1`, strings.NewReader(`{"name":"todd"}`)))
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestHookAfterInsert(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	var seen []interface{}
+	b := Bartlett{
+		DB:     db,
+		Driver: dummyDriver{},
+		Tables: []Table{
+			{
+				Name:     `letters`,
+				Writable: true,
+				Hooks: []Hook{
+					{
+						Operations: []Operation{OpInsert},
+						After: func(c *Call) error {
+							seen = append(seen, c.IDs...)
+							if len(seen) > 1 {
+								return errors.New(`only one letter at a time`)
+							}
+							return nil
+						},
+					},
+				},
+			},
+		},
+		Users: dummyUserProvider,
+	}
+
+	routes := b.Routes()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO letters`).WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectCommit()
+	resp := httptest.NewRecorder()
+	routes[0].Handler(resp, httptest.NewRequest(http.MethodPost, `/letters`, strings.NewReader(`{"a":"hello"}`)))
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+	if len(seen) != 1 || seen[0] != int64(7) {
+		t.Errorf(`Expected After hook to see ID 7 but got %+v`, seen)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO letters`).WillReturnResult(sqlmock.NewResult(8, 1))
+	mock.ExpectRollback()
+	resp = httptest.NewRecorder()
+	routes[0].Handler(resp, httptest.NewRequest(http.MethodPost, `/letters`, strings.NewReader(`{"a":"again"}`)))
+	if resp.Code != http.StatusInternalServerError {
+		t.Errorf(`Expected "500" but got %d for status code`, resp.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestHookAfterIDs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	seen := make(map[Operation][]interface{})
+	b := Bartlett{
+		DB:     db,
+		Driver: dummyDriver{},
+		Tables: []Table{
+			{
+				Name:     `letters`,
+				Writable: true,
+				Hooks: []Hook{
+					{
+						Operations: []Operation{OpUpdate, OpDelete},
+						Before: func(c *Call) error {
+							if c.Operation == OpUpdate {
+								c.Update = c.Update.Where(sqrl.Eq{`b`: `z`})
+							}
+							return nil
+						},
+						After: func(c *Call) error {
+							seen[c.Operation] = c.IDs
+							return nil
+						},
+					},
+				},
+			},
+		},
+		Users: dummyUserProvider,
+	}
+	handler := b.Handler()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM letters WHERE a = \? AND b = \?`).
+		WithArgs(`x`, `z`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`}).AddRow(int64(1)).AddRow(int64(2)))
+	mock.ExpectExec(`UPDATE letters SET name = \? WHERE a = \? AND b = \?`).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPatch, `/letters?a=eq.x`, strings.NewReader(`{"name":"y"}`)))
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM letters WHERE id = \?`).
+		WithArgs(`3`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`}).AddRow(int64(3)))
+	mock.ExpectExec(`DELETE FROM letters WHERE id = \?`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodDelete, `/letters/3`, nil))
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	if ids := seen[OpUpdate]; len(ids) != 2 || ids[0] != int64(1) || ids[1] != int64(2) {
+		t.Errorf(`Expected the update's After hook to see IDs 1 and 2 but got %+v`, ids)
+	}
+	if ids := seen[OpDelete]; len(ids) != 1 || ids[0] != int64(3) {
+		t.Errorf(`Expected the delete's After hook to see ID 3 but got %+v`, ids)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,284 @@
+package bartlett
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	sqrl "github.com/Masterminds/squirrel"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// An IdempotencyStore remembers the responses to POST requests that carried an `Idempotency-Key` header,
+// so that a client retrying a request gets the original response instead of duplicate rows.
+// Load and Save run inside the POST's transaction; Save is called just before it commits.
+// Load may reserve a key it hasn't seen, and return ErrIdempotencyInFlight for it until Save or Forget,
+// which Bartlett answers with 409 Conflict.
+// Forget is called whenever a POST that loaded a key doesn't commit, so that stores outside the database can drop
+// what they reserved or saved.
+type IdempotencyStore interface {
+	Load(ctx context.Context, tx *sql.Tx, key string, userID interface{}) (IdempotentResponse, bool, error)
+	Save(ctx context.Context, tx *sql.Tx, key string, userID interface{}, resp IdempotentResponse) error
+	Forget(key string, userID interface{})
+}
+
+// An IdempotentResponse is what a POST with an `Idempotency-Key` answered.
+// Hash identifies the request, so that reusing a key for a different request can be refused.
+type IdempotentResponse struct {
+	Hash   string
+	Status int
+	Body   []byte
+	Time   time.Time
+}
+
+// errIdempotencyConflict is returned when a key is reused for a different request.
+var errIdempotencyConflict = errors.New(`Idempotency-Key was already used for a different request`)
+
+// ErrIdempotencyInFlight is returned by an IdempotencyStore's Load while another request with the same key is running.
+var ErrIdempotencyInFlight = errors.New(`a request with this Idempotency-Key is still in progress`)
+
+// requestHash identifies a POST by its table and body.
+func requestHash(t Table, body []byte) string {
+	sum := sha256.Sum256(append([]byte(t.Name+"\x00"), body...))
+	return hex.EncodeToString(sum[:])
+}
+
+// replayPost answers a POST from the IdempotencyStore when its key has been seen before.
+// It reports whether the request has been answered.
+func (b Bartlett) replayPost(ctx context.Context, tx *sql.Tx, w http.ResponseWriter, r *http.Request, hash string) bool {
+	key := r.Header.Get(`Idempotency-Key`)
+	if b.Idempotency == nil || key == `` {
+		return false
+	}
+
+	resp, ok, err := b.Idempotency.Load(ctx, tx, key, b.auditUser(r))
+	if errors.Is(err, ErrIdempotencyInFlight) {
+		writeError(w, http.StatusConflict, err)
+		return true
+	}
+	if err != nil {
+		queryError(ctx, w, err)
+		return true
+	}
+	if !ok {
+		return false
+	}
+
+	replay(w, resp, hash)
+	return true
+}
+
+// replaySaved answers a POST whose Save failed with the response that a concurrent request with the same key saved
+// first, since a primary key conflict is all that a store in the database sees of it.
+// The transaction of the POST is no use after such a conflict, so the response is looked up in a new one.
+// It reports whether the request has been answered.
+func (b Bartlett) replaySaved(ctx context.Context, w http.ResponseWriter, r *http.Request, hash string) bool {
+	tx, err := b.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false
+	}
+	defer tx.Rollback()
+
+	resp, ok, err := b.Idempotency.Load(ctx, tx, r.Header.Get(`Idempotency-Key`), b.auditUser(r))
+	if err != nil || !ok {
+		return false
+	}
+
+	replay(w, resp, hash)
+	return true
+}
+
+// replay sends a saved response, or 409 Conflict if it answered a different request.
+func replay(w http.ResponseWriter, resp IdempotentResponse, hash string) {
+	if resp.Hash != hash {
+		writeError(w, http.StatusConflict, errIdempotencyConflict)
+		return
+	}
+
+	w.Header().Set(`Idempotent-Replayed`, `true`)
+	w.WriteHeader(resp.Status)
+	_, _ = w.Write(resp.Body)
+}
+
+// IdempotencyCache keeps idempotent responses in memory, so it only deduplicates retries that reach the same process.
+// A key is reserved from the first Load that misses it, so that concurrent requests with it are refused until the
+// first one is answered.
+// MaxEntries caps the number of responses kept, 10000 by default. The least recently used are dropped first.
+// TTL is how long a key is remembered; zero keeps keys until they are evicted.
+// The zero value is ready to use.
+type IdempotencyCache struct {
+	MaxEntries int
+	TTL        time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	recent  *list.List
+}
+
+type idempotencyEntry struct {
+	key     string
+	resp    IdempotentResponse
+	pending bool
+}
+
+func idempotencyCacheKey(key string, userID interface{}) string {
+	return fmt.Sprintf("%v\x00%s", userID, key)
+}
+
+// Load returns the response saved for key and userID. A key without one is reserved until Save or Forget,
+// and loading it again meanwhile returns ErrIdempotencyInFlight.
+func (c *IdempotencyCache) Load(_ context.Context, _ *sql.Tx, key string, userID interface{}) (IdempotentResponse, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k := idempotencyCacheKey(key, userID)
+	if el, ok := c.entries[k]; ok {
+		entry := el.Value.(idempotencyEntry)
+		if entry.pending {
+			return IdempotentResponse{}, false, ErrIdempotencyInFlight
+		}
+		if c.TTL <= 0 || time.Since(entry.resp.Time) <= c.TTL {
+			c.recent.MoveToFront(el)
+			return entry.resp, true, nil
+		}
+	}
+
+	c.put(idempotencyEntry{key: k, pending: true})
+	return IdempotentResponse{}, false, nil
+}
+
+// Save remembers resp for key and userID.
+func (c *IdempotencyCache) Save(_ context.Context, _ *sql.Tx, key string, userID interface{}, resp IdempotentResponse) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.put(idempotencyEntry{key: idempotencyCacheKey(key, userID), resp: resp})
+	return nil
+}
+
+// put adds or replaces an entry, evicting the least recently used beyond MaxEntries.
+func (c *IdempotencyCache) put(entry idempotencyEntry) {
+	if c.entries == nil {
+		c.entries = make(map[string]*list.Element)
+		c.recent = list.New()
+	}
+	if el, ok := c.entries[entry.key]; ok {
+		c.remove(el)
+	}
+	c.entries[entry.key] = c.recent.PushFront(entry)
+
+	max := c.MaxEntries
+	if max <= 0 {
+		max = 10000
+	}
+	for c.recent.Len() > max {
+		c.remove(c.recent.Back())
+	}
+}
+
+// Forget drops the response saved or the reservation made for key and userID.
+func (c *IdempotencyCache) Forget(key string, userID interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[idempotencyCacheKey(key, userID)]; ok {
+		c.remove(el)
+	}
+}
+
+func (c *IdempotencyCache) remove(el *list.Element) {
+	delete(c.entries, el.Value.(idempotencyEntry).key)
+	c.recent.Remove(el)
+}
+
+// IdempotencyTable keeps idempotent responses in a database table, in the same transaction as the rows they describe.
+// Concurrent retries are serialized by the table's primary key, so only one of them inserts anything.
+// The others fail to save their response and are answered with the one that was saved instead.
+// Create makes the table. TTL is how long a key is remembered; zero keeps keys forever.
+// Save times are stored as Unix seconds, which every driver reads back the same way.
+// Set Driver to have Name quoted as the Driver quotes table names.
+type IdempotencyTable struct {
+	Name   string
+	TTL    time.Duration
+	Driver Driver
+}
+
+// Create makes the table if it doesn't exist yet. Call it once at startup, outside of any transaction.
+func (a IdempotencyTable) Create(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		idempotency_key VARCHAR(255) NOT NULL,
+		user_id VARCHAR(255) NOT NULL,
+		request_hash CHAR(64) NOT NULL,
+		status INTEGER NOT NULL,
+		body TEXT NOT NULL,
+		created_at BIGINT NOT NULL,
+		PRIMARY KEY (idempotency_key, user_id)
+	)`, a.sqlName()))
+	return err
+}
+
+// Load returns the response saved for key and userID.
+func (a IdempotencyTable) Load(ctx context.Context, tx *sql.Tx, key string, userID interface{}) (IdempotentResponse, bool, error) {
+	var (
+		resp    IdempotentResponse
+		body    string
+		created int64
+	)
+	err := sqrl.Select(`request_hash`, `status`, `body`, `created_at`).
+		From(a.sqlName()).
+		Where(a.row(key, userID)).
+		RunWith(tx).
+		QueryRowContext(ctx).
+		Scan(&resp.Hash, &resp.Status, &body, &created)
+	if err == sql.ErrNoRows {
+		return resp, false, nil
+	}
+	if err != nil {
+		return resp, false, err
+	}
+	resp.Time = time.Unix(created, 0)
+	if a.TTL > 0 && time.Since(resp.Time) > a.TTL {
+		return resp, false, nil
+	}
+	resp.Body = []byte(body)
+
+	return resp, true, nil
+}
+
+// Save inserts resp, replacing an expired response for the same key.
+func (a IdempotencyTable) Save(ctx context.Context, tx *sql.Tx, key string, userID interface{}, resp IdempotentResponse) error {
+	if a.TTL > 0 {
+		_, err := sqrl.Delete(a.sqlName()).
+			Where(a.row(key, userID)).
+			Where(sqrl.Lt{`created_at`: resp.Time.Add(-a.TTL).Unix()}).
+			RunWith(tx).
+			ExecContext(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := sqrl.Insert(a.sqlName()).
+		Columns(`idempotency_key`, `user_id`, `request_hash`, `status`, `body`, `created_at`).
+		Values(key, fmt.Sprint(userID), resp.Hash, resp.Status, string(resp.Body), resp.Time.Unix()).
+		RunWith(tx).
+		ExecContext(ctx)
+	return err
+}
+
+// Forget does nothing; the failed transaction has already taken the saved response with it.
+func (a IdempotencyTable) Forget(string, interface{}) {}
+
+// sqlName is the table's name quoted for SQL, in the same way as the tables Bartlett serves.
+func (a IdempotencyTable) sqlName() string {
+	return Table{Name: a.Name, driver: a.Driver}.sqlName()
+}
+
+func (a IdempotencyTable) row(key string, userID interface{}) sqrl.Eq {
+	return sqrl.Eq{`idempotency_key`: key, `user_id`: fmt.Sprint(userID)}
+}
@@ -0,0 +1,263 @@
+package bartlett
+
+import (
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotentPost(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	b := Bartlett{
+		DB:     db,
+		Driver: dummyDriver{},
+		Tables: []Table{
+			{Name: `letters`, Writable: true},
+		},
+		Users:       dummyUserProvider,
+		Idempotency: &IdempotencyCache{},
+	}
+	handler := b.Handler()
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, `/letters`, strings.NewReader(body))
+		req.Header.Set(`Idempotency-Key`, key)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO letters`).WithArgs(`hello`).WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectCommit()
+	first := post(`abc`, `{"a":"hello"}`)
+	if first.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, first.Code, first.Body.String())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	replay := post(`abc`, `{"a":"hello"}`)
+	if replay.Code != http.StatusOK || replay.Body.String() != first.Body.String() {
+		t.Errorf(`Expected the original response %s but got %d %s`, first.Body.String(), replay.Code, replay.Body.String())
+	}
+	if replay.Header().Get(`Idempotent-Replayed`) != `true` {
+		t.Error(`Expected a replayed response to say so`)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	conflict := post(`abc`, `{"a":"goodbye"}`)
+	if conflict.Code != http.StatusConflict {
+		t.Errorf(`Expected "409" for a reused key but got %d for status code`, conflict.Code)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO letters`).WithArgs(`hi`).WillReturnResult(sqlmock.NewResult(8, 1))
+	mock.ExpectCommit().WillReturnError(errors.New(`connection lost`))
+	failed := post(`def`, `{"a":"hi"}`)
+	if failed.Code != http.StatusInternalServerError {
+		t.Errorf(`Expected "500" for a failed commit but got %d for status code`, failed.Code)
+	}
+	if _, ok, _ := b.Idempotency.Load(context.Background(), nil, `def`, 1); ok {
+		t.Error(`Expected a failed commit to forget its key`)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestIdempotencyCacheEviction(t *testing.T) {
+	c := &IdempotencyCache{MaxEntries: 1, TTL: time.Minute}
+	ctx := context.Background()
+	_ = c.Save(ctx, nil, `a`, 1, IdempotentResponse{Time: time.Now()})
+	_ = c.Save(ctx, nil, `b`, 1, IdempotentResponse{Time: time.Now()})
+	if _, ok, _ := c.Load(ctx, nil, `a`, 1); ok {
+		t.Error(`Expected the oldest key to be evicted`)
+	}
+	if _, ok, _ := c.Load(ctx, nil, `b`, 2); ok {
+		t.Error(`Expected keys to be scoped by user`)
+	}
+
+	_ = c.Save(ctx, nil, `c`, 1, IdempotentResponse{Time: time.Now().Add(-time.Hour)})
+	if _, ok, _ := c.Load(ctx, nil, `c`, 1); ok {
+		t.Error(`Expected an expired key to be forgotten`)
+	}
+}
+
+func TestIdempotencyTable(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	store := IdempotencyTable{Name: `idempotency`, TTL: time.Hour}
+	ctx := context.Background()
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT request_hash, status, body, created_at FROM idempotency WHERE idempotency_key = \? AND user_id = \?`).
+		WithArgs(`abc`, `1`).
+		WillReturnRows(sqlmock.NewRows([]string{`request_hash`, `status`, `body`, `created_at`}).
+			AddRow(`hash`, 200, `{"errors":[],"inserts":[7]}`, time.Now().Unix()))
+	mock.ExpectExec(`DELETE FROM idempotency WHERE idempotency_key = \? AND user_id = \? AND created_at < \?`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO idempotency \(idempotency_key,user_id,request_hash,status,body,created_at\)`).
+		WithArgs(`def`, `1`, `hash`, 200, `{}`, now.Unix()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, ok, err := store.Load(ctx, tx, `abc`, 1)
+	if err != nil || !ok || resp.Status != 200 || string(resp.Body) != `{"errors":[],"inserts":[7]}` {
+		t.Errorf(`Expected the stored response but got %+v, %t, %v`, resp, ok, err)
+	}
+	err = store.Save(ctx, tx, `def`, 1, IdempotentResponse{Hash: `hash`, Status: 200, Body: []byte(`{}`), Time: now})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestIdempotencyTableQuoting(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	store := IdempotencyTable{Name: `app.order keys`, Driver: quotingDriver{}}
+	ctx := context.Background()
+
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS `app`.`order keys`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT request_hash, status, body, created_at FROM `app`.`order keys` WHERE").
+		WillReturnRows(sqlmock.NewRows([]string{`request_hash`, `status`, `body`, `created_at`}))
+	mock.ExpectExec("INSERT INTO `app`.`order keys`").WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := store.Create(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok, err := store.Load(ctx, tx, `abc`, 1); ok || err != nil {
+		t.Errorf(`Expected a miss but got %t, %v`, ok, err)
+	}
+	if err := store.Save(ctx, tx, `abc`, 1, IdempotentResponse{Time: time.Now()}); err != nil {
+		t.Error(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestIdempotencyInFlight(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	store := &IdempotencyCache{}
+	b := Bartlett{
+		DB:     db,
+		Driver: dummyDriver{},
+		Tables: []Table{
+			{Name: `letters`, Writable: true},
+		},
+		Users:       dummyUserProvider,
+		Idempotency: store,
+	}
+	handler := b.Handler()
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, `/letters`, strings.NewReader(`{"a":"hello"}`))
+		req.Header.Set(`Idempotency-Key`, `abc`)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	ctx := context.Background()
+	if _, ok, err := store.Load(ctx, nil, `abc`, 1); ok || err != nil {
+		t.Fatalf(`Expected a miss that reserves the key but got %t, %v`, ok, err)
+	}
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	if resp := post(); resp.Code != http.StatusConflict {
+		t.Errorf(`Expected "409" while the key is in flight but got %d for status code`, resp.Code)
+	}
+
+	store.Forget(`abc`, 1)
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO letters`).WillReturnError(errors.New(`no`))
+	mock.ExpectCommit()
+	if resp := post(); resp.Code != http.StatusBadRequest {
+		t.Errorf(`Expected "400" when nothing was inserted but got %d for status code`, resp.Code)
+	}
+	if _, _, err := store.Load(ctx, nil, `abc`, 1); err != nil {
+		t.Errorf(`Expected the key to be saved, not left reserved, but got %v`, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestIdempotencyTableConflict(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	b := Bartlett{
+		DB:     db,
+		Driver: dummyDriver{},
+		Tables: []Table{
+			{Name: `letters`, Writable: true},
+		},
+		Users:       dummyUserProvider,
+		Idempotency: IdempotencyTable{Name: `idempotency`},
+	}
+	hash := requestHash(Table{Name: `letters`}, []byte(`{"a":"hello"}`))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT request_hash, status, body, created_at FROM idempotency`).
+		WillReturnRows(sqlmock.NewRows([]string{`request_hash`, `status`, `body`, `created_at`}))
+	mock.ExpectExec(`INSERT INTO letters`).WillReturnResult(sqlmock.NewResult(8, 1))
+	mock.ExpectExec(`INSERT INTO idempotency`).WillReturnError(errors.New(`Duplicate entry 'abc-1' for key 'PRIMARY'`))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT request_hash, status, body, created_at FROM idempotency`).
+		WillReturnRows(sqlmock.NewRows([]string{`request_hash`, `status`, `body`, `created_at`}).
+			AddRow(hash, 200, `{"errors":[],"inserts":[7]}`, time.Now().Unix()))
+	mock.ExpectRollback()
+	req := httptest.NewRequest(http.MethodPost, `/letters`, strings.NewReader(`{"a":"hello"}`))
+	req.Header.Set(`Idempotency-Key`, `abc`)
+	resp := httptest.NewRecorder()
+	b.Handler().ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK || resp.Body.String() != `{"errors":[],"inserts":[7]}` {
+		t.Errorf(`Expected the concurrent request's response but got %d with body %s`, resp.Code, resp.Body.String())
+	}
+	if resp.Header().Get(`Idempotent-Replayed`) != `true` {
+		t.Error(`Expected a replayed response to say so`)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
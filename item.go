@@ -0,0 +1,43 @@
+package bartlett
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+)
+
+// itemKey marks a request addressed to a single row, as in `/students/42`.
+type itemKey struct{}
+
+func withItem(r *http.Request, id string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), itemKey{}, id))
+}
+
+// itemID returns the primary key value from an item route, if the request came through one.
+func itemID(r *http.Request) (string, bool) {
+	id, ok := r.Context().Value(itemKey{}).(string)
+	return id, ok
+}
+
+// bufferedWriter collects a response so that it can be inspected before anything reaches the client.
+type bufferedWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedWriter() *bufferedWriter {
+	return &bufferedWriter{header: make(http.Header), status: http.StatusOK}
+}
+
+func (w *bufferedWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *bufferedWriter) WriteHeader(status int) {
+	w.status = status
+}
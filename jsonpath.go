@@ -0,0 +1,155 @@
+package bartlett
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/buger/jsonparser"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// jsonKey is a single step of a JSON path: an object key or an array index.
+var jsonKey = regexp.MustCompile(`^(?:[A-Za-z_][A-Za-z0-9_]*|\d+)$`)
+
+// A jsonPath reaches into a JSON column, as in `data->address->city`.
+// Path is the SQL/JSON path of the value, eg `$.address.city`, and Key is the name it gets in the output:
+// its last object key, followed by any array indexes after it, as in `tags_0` for `data->tags->0`.
+// Text is set by a final `->>`, which yields the value as text rather than as JSON.
+type jsonPath struct {
+	Column string
+	Path   string
+	Key    string
+	Text   bool
+}
+
+// parseJSONPath reads a name such as `data->tags->0` or `data->>status`. Only the last step may use `->>`.
+// Every step must be an identifier or an array index, since the path ends up in the SQL text.
+func parseJSONPath(name string) (jsonPath, bool) {
+	steps := strings.Split(name, `->`)
+	if len(steps) < 2 {
+		return jsonPath{}, false
+	}
+
+	path := jsonPath{Column: steps[0], Path: `$`, Key: steps[0]}
+	for i, step := range steps[1:] {
+		if strings.HasPrefix(step, `>`) {
+			if i != len(steps)-2 {
+				return jsonPath{}, false
+			}
+			step = step[1:]
+			path.Text = true
+		}
+		if !jsonKey.MatchString(step) {
+			return jsonPath{}, false
+		}
+		if step[0] >= '0' && step[0] <= '9' {
+			path.Path += `[` + step + `]`
+			path.Key += `_` + step
+		} else {
+			path.Path += `.` + step
+			path.Key = step
+		}
+	}
+
+	return path, true
+}
+
+// readable reports whether name is a column of the table or a JSON path into one.
+func (t Table) readable(name string) bool {
+	if path, ok := parseJSONPath(name); ok {
+		name = path.Column
+	}
+	return sliceContains(t.columns, name)
+}
+
+// columnSQL is the SQL for a readable name: the quoted column, or the Driver's expression for a JSON path.
+func (t Table) columnSQL(name string) string {
+	path, ok := parseJSONPath(name)
+	if !ok {
+		return t.quote(name)
+	}
+	column := t.quote(path.Column)
+	if t.driver != nil {
+		return t.driver.JSONPath(column, path.Path, path.Text)
+	}
+	if path.Text {
+		return fmt.Sprintf(`JSON_VALUE(%s, '%s')`, column, path.Path)
+	}
+	return fmt.Sprintf(`JSON_QUERY(%s, '%s')`, column, path.Path)
+}
+
+// selectSQL is the SQL for a `select` column, naming a JSON path after its last step.
+func (t Table) selectSQL(name string) string {
+	if path, ok := parseJSONPath(name); ok {
+		return fmt.Sprintf(`%s AS %s`, t.columnSQL(name), t.quote(path.Key))
+	}
+	return t.quote(name)
+}
+
+// jsonKeys lists the output keys that hold JSON documents: the table's JSON columns and any `->` paths in `select`.
+func (t Table) jsonKeys(r *http.Request) []string {
+	keys := t.jsonColumns()
+	for _, name := range parseColumns(t, r) {
+		if path, ok := parseJSONPath(name); ok && !path.Text {
+			keys = append(keys, path.Key)
+		}
+	}
+
+	return keys
+}
+
+// jsonColumns lists the table's JSON columns.
+func (t Table) jsonColumns() []string {
+	var keys []string
+	for _, col := range t.columnInfo {
+		if strings.EqualFold(col.Type, `json`) {
+			keys = append(keys, col.Name)
+		}
+	}
+
+	return keys
+}
+
+// marshalRows renders a result set of t as the API sends it, with the documents under keys embedded as JSON.
+// Anything that hashes rows into ETags must render them this way, so that the tags agree.
+func (b Bartlett) marshalRows(t Table, rows *sql.Rows, keys []string) ([]byte, error) {
+	buf := newBufferedWriter()
+	err := b.Driver.MarshalResults(rows, buf, b.encoding(t))
+	if err != nil {
+		return nil, err
+	}
+
+	return embedJSON(buf.body.Bytes(), keys)
+}
+
+// embedJSON replaces string values that hold JSON documents with the documents themselves,
+// since drivers hand JSON back as text. Values that aren't valid JSON are left as strings.
+func embedJSON(body []byte, keys []string) ([]byte, error) {
+	if len(keys) == 0 {
+		return body, nil
+	}
+
+	var rows [][]byte
+	_, err := jsonparser.ArrayEach(body, func(row []byte, dataType jsonparser.ValueType, offset int, err error) {
+		for _, key := range keys {
+			val, dataType, _, err := jsonparser.Get(row, key)
+			if err != nil || dataType != jsonparser.String {
+				continue
+			}
+			doc, err := jsonparser.ParseString(val)
+			if err != nil || !json.Valid([]byte(doc)) {
+				continue
+			}
+			row, _ = jsonparser.Set(append([]byte(nil), row...), []byte(doc), key)
+		}
+		rows = append(rows, row)
+	})
+	if err != nil {
+		return body, err
+	}
+
+	return append(append([]byte{'['}, bytes.Join(rows, []byte{','})...), ']'), nil
+}
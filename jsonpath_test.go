@@ -0,0 +1,74 @@
+package bartlett
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	cases := []struct {
+		name string
+		path string
+		key  string
+		text bool
+	}{
+		{`data->address->city`, `$.address.city`, `city`, false},
+		{`data->>status`, `$.status`, `status`, true},
+		{`data->tags->0`, `$.tags[0]`, `tags_0`, false},
+		{`data->0->>name`, `$[0].name`, `name`, true},
+	}
+	for _, c := range cases {
+		path, ok := parseJSONPath(c.name)
+		if !ok || path.Column != `data` || path.Path != c.path || path.Key != c.key || path.Text != c.text {
+			t.Errorf(`Expected %s to give %s as %s but got %+v`, c.name, c.path, c.key, path)
+		}
+	}
+
+	for _, name := range []string{`data`, `data->>a->b`, `data->a b`, `data->'a'`, `data->`} {
+		if _, ok := parseJSONPath(name); ok {
+			t.Errorf(`Expected %s to be refused`, name)
+		}
+	}
+}
+
+func TestSelectJSONPath(t *testing.T) {
+	tbl := Table{Name: `students`, driver: dummyDriver{}}
+	tbl.setColumns([]Column{{Name: `id`}, {Name: `data`, Type: `JSON`}})
+	req := httptest.NewRequest(http.MethodGet, `/students?select=id,data->address->city,nope->a&data->>status=eq.active&order=data->>age.asc`, nil)
+
+	b := Bartlett{Driver: dummyDriver{}, Users: dummyUserProvider}
+	builder, err := b.buildSelect(tbl, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, args, err := builder.ToSql()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `SELECT id, json_path(data, '$.address.city', false) AS city FROM students WHERE json_path(data, '$.status', true) = ? ORDER BY json_path(data, '$.age', true) ASC`
+	if query != expected {
+		t.Errorf(`Expected %s but got %s`, expected, query)
+	}
+	if len(args) != 1 || args[0] != `active` {
+		t.Errorf(`Expected [active] but got %v`, args)
+	}
+
+	keys := tbl.jsonKeys(req)
+	if len(keys) != 2 || keys[0] != `data` || keys[1] != `city` {
+		t.Errorf(`Expected [data city] but got %v`, keys)
+	}
+}
+
+func TestEmbedJSON(t *testing.T) {
+	body, err := embedJSON([]byte(`[{"id":1,"data":"{\"a\":[1,2]}","city":"\"Oslo\""},{"id":2,"data":"not json","city":null}]`), []string{`data`, `city`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[{"id":1,"data":{"a":[1,2]},"city":"Oslo"},{"id":2,"data":"not json","city":null}]`
+	if string(body) != expected {
+		t.Errorf(`Expected %s but got %s`, expected, body)
+	}
+}
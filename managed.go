@@ -0,0 +1,82 @@
+package bartlett
+
+import (
+	sqrl "github.com/Masterminds/squirrel"
+	"net/http"
+)
+
+// A ManagedColumn is filled in by the server instead of the API user, such as a creation time or the author of a row.
+// OnInsert and OnUpdate compute the column's value for each INSERT and UPDATE. Either may be nil to leave the column
+// alone for that operation.
+// They receive the request and the ID from the UserIDProvider, or nil if there is none.
+// A value may be a squirrel expression such as `sqrl.Expr("CURRENT_TIMESTAMP")` to have the database compute it.
+// Values sent for the column in request bodies are dropped, even for an operation whose function is nil.
+type ManagedColumn struct {
+	Name     string
+	OnInsert func(r *http.Request, userID interface{}) interface{}
+	OnUpdate func(r *http.Request, userID interface{}) interface{}
+}
+
+func now(_ *http.Request, _ interface{}) interface{} {
+	return sqrl.Expr(`CURRENT_TIMESTAMP`)
+}
+
+func requestUser(_ *http.Request, userID interface{}) interface{} {
+	return userID
+}
+
+// CreatedAt sets a column to the current time when a row is inserted.
+func CreatedAt(name string) ManagedColumn {
+	return ManagedColumn{Name: name, OnInsert: now}
+}
+
+// UpdatedAt sets a column to the current time whenever a row is inserted or updated.
+func UpdatedAt(name string) ManagedColumn {
+	return ManagedColumn{Name: name, OnInsert: now, OnUpdate: now}
+}
+
+// CreatedBy sets a column to the ID of the user who inserted the row.
+func CreatedBy(name string) ManagedColumn {
+	return ManagedColumn{Name: name, OnInsert: requestUser}
+}
+
+// UpdatedBy sets a column to the ID of the user who last inserted or updated the row.
+func UpdatedBy(name string) ManagedColumn {
+	return ManagedColumn{Name: name, OnInsert: requestUser, OnUpdate: requestUser}
+}
+
+// A columnValue is one column of a write that the server fills in.
+type columnValue struct {
+	Name  string
+	Value interface{}
+}
+
+// managedValues computes the server-managed columns for an INSERT or UPDATE.
+func (b Bartlett) managedValues(t Table, op Operation, r *http.Request) []columnValue {
+	if len(t.Managed) == 0 {
+		return nil
+	}
+
+	userID := b.auditUser(r)
+	var out []columnValue
+	for _, col := range t.Managed {
+		value := col.OnInsert
+		if op == OpUpdate {
+			value = col.OnUpdate
+		}
+		if value != nil {
+			out = append(out, columnValue{Name: col.Name, Value: value(r, userID)})
+		}
+	}
+
+	return out
+}
+
+func (t Table) isManaged(name string) bool {
+	for _, col := range t.Managed {
+		if col.Name == name {
+			return true
+		}
+	}
+	return false
+}
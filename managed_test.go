@@ -0,0 +1,134 @@
+package bartlett
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	sqrl "github.com/Masterminds/squirrel"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestManagedColumns(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	b := Bartlett{
+		DB:     db,
+		Driver: dummyDriver{},
+		Tables: []Table{
+			{
+				Name:     `students`,
+				Writable: true,
+				Managed:  []ManagedColumn{CreatedAt(`a`), UpdatedAt(`b`), CreatedBy(`name`)},
+			},
+		},
+		Users: dummyUserProvider,
+	}
+	handler := b.Handler()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO students \(id,a,b,name\) VALUES \(\?,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,\?\)`).
+		WithArgs(`7`, 1).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectCommit()
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, `/students`, strings.NewReader(`{"id":7,"a":"then","name":"someone else"}`)))
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE students SET b = CURRENT_TIMESTAMP WHERE id = \?`).
+		WithArgs(`7`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPatch, `/students/7`, strings.NewReader(`{"a":"then","b":"never"}`)))
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestManagedColumnCustom(t *testing.T) {
+	tbl := Table{
+		columns: []string{`a`, `slug`},
+		Name:    `letters`,
+		Managed: []ManagedColumn{{
+			Name: `slug`,
+			OnUpdate: func(r *http.Request, _ interface{}) interface{} {
+				return r.Header.Get(`X-Slug`)
+			},
+		}},
+	}
+	req := httptest.NewRequest(http.MethodPatch, `/letters`, nil)
+	req.Header.Set(`X-Slug`, `alpha`)
+	managed := Bartlett{}.managedValues(tbl, OpUpdate, req)
+
+	sql, args, err := tbl.prepareUpdate([]byte(`{"a": "test", "slug": "disregard"}`), nil, sqrl.Update(`letters`), managed).ToSql()
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if sql != `UPDATE letters SET a = ?, slug = ?` {
+		t.Errorf(`Expected "UPDATE letters SET a = ?, slug = ?" but got %s`, sql)
+	}
+	if len(args) != 2 || args[1] != `alpha` {
+		t.Errorf(`Expected the slug from the request but got %v`, args)
+	}
+
+	if len(Bartlett{}.managedValues(tbl, OpInsert, req)) != 0 {
+		t.Errorf(`Expected no value on insert for a column without OnInsert`)
+	}
+}
+
+func TestManagedColumnPerRow(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	serial := 0
+	b := Bartlett{
+		DB:     db,
+		Driver: dummyDriver{},
+		Tables: []Table{
+			{
+				Name:     `students`,
+				Writable: true,
+				Managed: []ManagedColumn{{
+					Name: `a`,
+					OnInsert: func(_ *http.Request, _ interface{}) interface{} {
+						serial++
+						return serial
+					},
+				}},
+			},
+		},
+		Users: dummyUserProvider,
+	}
+	handler := b.Handler()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO students \(id,a\) VALUES \(\?,\?\)`).
+		WithArgs(`7`, 1).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectExec(`INSERT INTO students \(id,a\) VALUES \(\?,\?\)`).
+		WithArgs(`8`, 2).
+		WillReturnResult(sqlmock.NewResult(8, 1))
+	mock.ExpectCommit()
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, `/students`, strings.NewReader(`[{"id":7},{"id":8}]`)))
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
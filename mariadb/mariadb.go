@@ -2,25 +2,21 @@
 package mariadb
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"github.com/royallthefourth/bartlett"
-	"log"
 	"net/http"
 	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
 // MariaDB provides logic specific to MariaDB and probably other MySQL compatibles, but MariaDB is the target.
-type MariaDB struct {
-	tables map[string][]column
-}
-
-type column struct {
-	dataType reflect.Type
-	name     string
-}
+// It holds no state, so a single value may be shared between Bartlett instances and concurrent calls to Refresh.
+type MariaDB struct{}
 
 type sqlColumn struct {
 	Field   string
@@ -32,17 +28,14 @@ type sqlColumn struct {
 }
 
 // GetColumns invokes `SHOW COLUMNS` and uses the output to determine valid columns for each table.
-func (driver *MariaDB) GetColumns(db *sql.DB, t bartlett.Table) ([]string, error) {
-	if driver.tables == nil {
-		driver.tables = make(map[string][]column)
-	}
-	rows, err := db.Query(fmt.Sprintf(`SHOW COLUMNS FROM %s`, t.Name))
-	defer rows.Close()
+func (driver *MariaDB) GetColumns(ctx context.Context, db *sql.DB, t bartlett.Table) ([]bartlett.Column, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`SHOW COLUMNS FROM %s`, qualified(t.Name)))
 	if err != nil {
-		return []string{}, err
+		return []bartlett.Column{}, err
 	}
+	defer rows.Close()
 
-	columns := make([]string, 0)
+	columns := make([]bartlett.Column, 0)
 
 	for rows.Next() {
 		var c sqlColumn
@@ -50,15 +43,134 @@ func (driver *MariaDB) GetColumns(db *sql.DB, t bartlett.Table) ([]string, error
 		if err != nil {
 			return columns, err
 		}
-		columns = append(columns, c.Field)
-		driver.tables[t.Name] = append(driver.tables[t.Name], column{name: c.Field, dataType: mysqlTypeToGo(c.Type)})
+		extra := strings.ToLower(c.Extra)
+		generated := strings.Contains(extra, `generated`) || strings.Contains(extra, `virtual`) || strings.Contains(extra, `persistent`)
+		col := bartlett.Column{
+			Name:       c.Field,
+			Type:       c.Type,
+			PrimaryKey: c.Key == `PRI`,
+			NotNull:    c.Null == `NO`,
+			HasDefault: c.Default != nil || strings.Contains(extra, `auto_increment`) || generated,
+			Length:     typeLength(c.Type),
+			Enum:       enumValues(c.Type),
+			Generated:  generated,
+		}
+		if c.Default != nil {
+			col.Default = fmt.Sprintf(`DEFAULT(%s)`, quoteIdent(c.Field))
+		}
+		columns = append(columns, col)
+	}
+	if err = rows.Err(); err != nil {
+		return columns, err
+	}
+
+	keys, err := foreignKeys(ctx, db, t.Name)
+	if err != nil {
+		return columns, err
+	}
+	docs := jsonColumns(ctx, db, t.Name)
+	for i, col := range columns {
+		columns[i].References = keys[col.Name]
+		if docs[col.Name] {
+			columns[i].Type = `json`
+		}
+	}
+
+	return columns, nil
+}
+
+var jsonValid = regexp.MustCompile("^json_valid\\(`?([^`)]+)`?\\)$")
+
+// jsonColumns finds the columns declared as JSON. MariaDB stores them as LONGTEXT with a json_valid check,
+// which is all that SHOW COLUMNS reveals of them. MySQL reports JSON columns as such and needs no lookup.
+// The lookup is best-effort: MySQL and MariaDB before 10.2.22 have no table_name in check_constraints,
+// so any error leaves the columns as text.
+func jsonColumns(ctx context.Context, db *sql.DB, table string) map[string]bool {
+	schema := ``
+	if i := strings.Index(table, `.`); i >= 0 {
+		schema, table = table[:i], table[i+1:]
+	}
+	rows, err := db.QueryContext(
+		ctx,
+		`SELECT check_clause FROM information_schema.check_constraints
+		WHERE constraint_schema = COALESCE(NULLIF(?, ''), database()) AND table_name = ?`,
+		schema, table)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	docs := make(map[string]bool)
+	for rows.Next() {
+		var clause string
+		if err := rows.Scan(&clause); err != nil {
+			return nil
+		}
+		if match := jsonValid.FindStringSubmatch(clause); match != nil {
+			docs[match[1]] = true
+		}
+	}
+	if rows.Err() != nil {
+		return nil
 	}
 
-	return columns, err
+	return docs
+}
+
+// qualified quotes a table name, and its schema separately if it has one.
+func qualified(name string) string {
+	if i := strings.Index(name, `.`); i >= 0 {
+		return quoteIdent(name[:i]) + `.` + quoteIdent(name[i+1:])
+	}
+	return quoteIdent(name)
+}
+
+// foreignKeys maps each column of a table that has a foreign key to the column it references.
+// Composite foreign keys are left out, as a single column can't follow them.
+func foreignKeys(ctx context.Context, db *sql.DB, table string) (map[string]*bartlett.ForeignKey, error) {
+	schema := ``
+	if i := strings.Index(table, `.`); i >= 0 {
+		schema, table = table[:i], table[i+1:]
+	}
+	rows, err := db.QueryContext(
+		ctx,
+		`SELECT constraint_name, column_name,
+			IF(referenced_table_schema = database(), referenced_table_name, CONCAT(referenced_table_schema, '.', referenced_table_name)),
+			referenced_column_name
+		FROM information_schema.key_column_usage
+		WHERE table_schema = COALESCE(NULLIF(?, ''), database()) AND table_name = ? AND referenced_table_name IS NOT NULL`,
+		schema, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make(map[string]*bartlett.ForeignKey)
+	constraints := make(map[string][]string)
+	for rows.Next() {
+		var (
+			constraint, name string
+			key              bartlett.ForeignKey
+		)
+		if err := rows.Scan(&constraint, &name, &key.Table, &key.Column); err != nil {
+			return nil, err
+		}
+		keys[name] = &key
+		constraints[constraint] = append(constraints[constraint], name)
+	}
+	for _, names := range constraints {
+		if len(names) > 1 {
+			for _, name := range names {
+				delete(keys, name)
+			}
+		}
+	}
+
+	return keys, rows.Err()
 }
 
 // MarshalResults converts from MariaDB types to Go types, then outputs JSON to the ResponseWriter.
-func (MariaDB) MarshalResults(rows *sql.Rows, w http.ResponseWriter) error {
+func (MariaDB) MarshalResults(rows *sql.Rows, w http.ResponseWriter, enc bartlett.Encoding) error {
 	columns, err := rows.Columns()
 	if err != nil {
 		return fmt.Errorf(`column error: %v`, err)
@@ -69,16 +181,47 @@ func (MariaDB) MarshalResults(rows *sql.Rows, w http.ResponseWriter) error {
 		return fmt.Errorf(`column type error: %v`, err)
 	}
 
-	types := make([]reflect.Type, len(columnTypes))
+	results := make([]resultColumn, len(columnTypes))
 	for i, columnType := range columnTypes {
-		types[i] = mysqlTypeToGo(columnType.DatabaseTypeName())
+		nullable, ok := columnType.Nullable()
+		results[i] = resultColumn{
+			name:     columns[i],
+			dbType:   columnType.DatabaseTypeName(),
+			nullable: nullable || !ok, // Expressions may not say; better safe than a failed scan.
+		}
+	}
+
+	return marshalRows(rows, w, results, enc)
+}
+
+// A resultColumn is what marshalRows needs to know about a column of a result set.
+type resultColumn struct {
+	name     string
+	dbType   string
+	nullable bool
+}
+
+// scanType is where a column's values are scanned. Nullable columns scan into a pointer, which NULL leaves nil.
+func (c resultColumn) scanType() reflect.Type {
+	t := mysqlTypeToGo(c.dbType)
+	if c.nullable {
+		return reflect.PtrTo(t)
+	}
+	return t
+}
 
+func marshalRows(rows *sql.Rows, w http.ResponseWriter, columns []resultColumn, enc bartlett.Encoding) error {
+	types := make([]reflect.Type, len(columns))
+	encoders := make([]bartlett.Encoder, len(columns))
+	for i, col := range columns {
+		types[i] = col.scanType()
+		encoders[i] = enc.Encoder(col.name, col.dbType)
 	}
 
-	values := make([]interface{}, len(columnTypes))
+	values := make([]interface{}, len(columns))
 	data := make(map[string]interface{})
 
-	_, err = w.Write([]byte{'['})
+	_, err := w.Write([]byte{'['})
 	if err != nil {
 		return fmt.Errorf(`failed to write opening bracket: %s`, err)
 	}
@@ -101,7 +244,14 @@ func (MariaDB) MarshalResults(rows *sql.Rows, w http.ResponseWriter) error {
 			return fmt.Errorf(`failed to scan values: %v`, err)
 		}
 		for i, v := range values {
-			data[columns[i]] = v
+			if encoders[i] == nil {
+				data[columns[i].name] = v
+				continue
+			}
+			data[columns[i].name], err = encoders[i](scanned(v))
+			if err != nil {
+				return fmt.Errorf(`failed to encode %s: %v`, columns[i].name, err)
+			}
 		}
 
 		jsonRow, err := json.Marshal(data)
@@ -114,6 +264,9 @@ func (MariaDB) MarshalResults(rows *sql.Rows, w http.ResponseWriter) error {
 			return fmt.Errorf(`failed to write closing bracket: %s`, err)
 		}
 	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf(`failed to read rows: %v`, err)
+	}
 
 	_, err = w.Write([]byte{']'})
 	if err != nil {
@@ -123,10 +276,48 @@ func (MariaDB) MarshalResults(rows *sql.Rows, w http.ResponseWriter) error {
 	return err
 }
 
-func (driver *MariaDB) ProbeTables(db *sql.DB) []bartlett.Table {
-	rows, err := db.Query(`SELECT table_name FROM information_schema.tables WHERE table_schema = database()`)
+// scanned is the value behind a scan destination, or nil for NULL.
+func scanned(v interface{}) interface{} {
+	val := reflect.ValueOf(v)
+	for val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return nil
+		}
+		val = val.Elem()
+	}
+	if b, ok := val.Interface().(bit); ok {
+		return uint64(b)
+	}
+	return val.Interface()
+}
+
+// bit scans a BIT column, which MariaDB sends as big-endian bytes. BIT(1) flags come out as 0 and 1.
+type bit uint64
+
+func (b *bit) Scan(src interface{}) error {
+	switch val := src.(type) {
+	case []byte:
+		*b = 0
+		for _, c := range val {
+			*b = *b<<8 | bit(c)
+		}
+	case int64:
+		*b = bit(val)
+	default:
+		return fmt.Errorf(`cannot scan %T into BIT`, src)
+	}
+	return nil
+}
+
+// ProbeTables lists the base tables in schema, or in the current database if schema is blank.
+func (driver *MariaDB) ProbeTables(ctx context.Context, db *sql.DB, schema string) ([]bartlett.Table, error) {
+	rows, err := db.QueryContext(
+		ctx,
+		`SELECT table_name FROM information_schema.tables
+		WHERE table_schema = COALESCE(NULLIF(?, ''), database()) AND table_type = 'BASE TABLE'`,
+		schema)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -135,21 +326,57 @@ func (driver *MariaDB) ProbeTables(db *sql.DB) []bartlett.Table {
 	for rows.Next() {
 		var name string
 		if err := rows.Scan(&name); err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 
+		if schema != `` {
+			name = fmt.Sprintf(`%s.%s`, schema, name)
+		}
 		tables = append(tables, bartlett.Table{Name: name})
 	}
 
-	return tables
+	return tables, rows.Err()
+}
+
+// FilterSQL uses MariaDB's null-safe equality operator `<=>`, which predates IS DISTINCT FROM.
+// LIKE follows the column's collation, so ilike compares both sides in lower case.
+func (MariaDB) FilterSQL(op, column string) (string, bool) {
+	switch op {
+	case `nseq`:
+		return fmt.Sprintf(`%s <=> ?`, column), true
+	case `isdistinct`:
+		return fmt.Sprintf(`NOT (%s <=> ?)`, column), true
+	case `ilike`:
+		return fmt.Sprintf(`LOWER(%s) LIKE LOWER(?)`, column), true
+	default:
+		return ``, false
+	}
+}
+
+// QuoteIdent quotes a name in backticks, which work whether or not ANSI_QUOTES is set.
+func (MariaDB) QuoteIdent(name string) string {
+	return quoteIdent(name)
+}
+
+func quoteIdent(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+// JSONPath uses JSON_EXTRACT, which returns JSON text, and JSON_UNQUOTE to turn a JSON string into plain text.
+func (MariaDB) JSONPath(column, path string, text bool) string {
+	if text {
+		return fmt.Sprintf(`JSON_UNQUOTE(JSON_EXTRACT(%s, '%s'))`, column, path)
+	}
+	return fmt.Sprintf(`JSON_EXTRACT(%s, '%s')`, column, path)
 }
 
 // Driver gives weird results for column types by default.
 // Let's pick our own types instead from https://github.com/go-sql-driver/mysql/blob/c45f530f8e7fe40f4687eaa50d0c8c5f1b66f9e0/fields.go#L16
+// Integers get a type wide enough for their UNSIGNED range, which the driver's type names don't mention.
 func mysqlTypeToGo(t string) reflect.Type {
-	switch strings.ToUpper(t) { // sure would like to have every branch of this converted to json by tests
+	switch strings.ToUpper(t) { // Every branch is converted to JSON by TestTypeMatrix.
 	case `BIT`:
-		return reflect.TypeOf(true)
+		return reflect.TypeOf(bit(0))
 	case `BLOB`:
 		return reflect.TypeOf([]byte{})
 	case `TEXT`:
@@ -171,24 +398,24 @@ func mysqlTypeToGo(t string) reflect.Type {
 	case `MEDIUMINT`:
 		return reflect.TypeOf(int32(0))
 	case `JSON`:
-		return reflect.TypeOf([]byte{})
+		return reflect.TypeOf(``)
 	case `INT`:
-		return reflect.TypeOf(int(0))
+		return reflect.TypeOf(int64(0))
 	case `LONGTEXT`:
-		return reflect.TypeOf([]byte{})
+		return reflect.TypeOf(``)
 	case `LONGBLOB`:
 		return reflect.TypeOf([]byte{})
 	case `BIGINT`:
 		return reflect.TypeOf(int64(0))
 	case `MEDIUMTEXT`:
-		return reflect.TypeOf([]byte{})
+		return reflect.TypeOf(``)
 	case `MEDIUMBLOB`:
 		return reflect.TypeOf([]byte{})
 	case `CHAR`:
 		return reflect.TypeOf(``)
 	case `BINARY`:
 		return reflect.TypeOf([]byte{})
-	case `VARCAHR`:
+	case `VARCHAR`:
 		return reflect.TypeOf(``)
 	case `VARBINARY`:
 		return reflect.TypeOf([]byte{})
@@ -197,14 +424,59 @@ func mysqlTypeToGo(t string) reflect.Type {
 	case `TIMESTAMP`:
 		return reflect.TypeOf(``)
 	case `SMALLINT`:
-		return reflect.TypeOf(int16(0))
+		return reflect.TypeOf(int32(0))
 	case `SET`:
-		return reflect.TypeOf([]byte{})
+		return reflect.TypeOf(``)
 	case `TINYINT`:
-		return reflect.TypeOf(int8(0))
+		return reflect.TypeOf(int16(0))
+	case `TINYBLOB`:
+		return reflect.TypeOf([]byte{})
 	case `YEAR`:
 		return reflect.TypeOf(int16(0))
 	default:
 		return reflect.TypeOf(``)
 	}
 }
+
+var charLength = regexp.MustCompile(`^(?i)(?:var)?char\((\d+)\)`)
+
+// typeLength reads the maximum length from a type such as `varchar(40)`.
+func typeLength(t string) int {
+	match := charLength.FindStringSubmatch(t)
+	if match == nil {
+		return 0
+	}
+	length, _ := strconv.Atoi(match[1])
+	return length
+}
+
+// enumValues reads the permitted values from a type such as `enum('a','b')`, where quotes are escaped by doubling.
+func enumValues(t string) []string {
+	if !strings.HasPrefix(strings.ToLower(t), `enum(`) || !strings.HasSuffix(t, `)`) {
+		return nil
+	}
+
+	var (
+		values  []string
+		current strings.Builder
+		quoted  bool
+	)
+	list := t[len(`enum(`) : len(t)-1]
+	for i := 0; i < len(list); i++ {
+		switch {
+		case list[i] == '\'' && quoted && i+1 < len(list) && list[i+1] == '\'':
+			current.WriteByte('\'')
+			i++
+		case list[i] == '\'':
+			quoted = !quoted
+			if !quoted {
+				values = append(values, current.String())
+				current.Reset()
+			}
+		case quoted:
+			current.WriteByte(list[i])
+		}
+	}
+
+	return values
+}
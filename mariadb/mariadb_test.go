@@ -1,13 +1,18 @@
 package mariadb
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"flag"
+	"github.com/DATA-DOG/go-sqlmock"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/royallthefourth/bartlett"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -67,7 +72,10 @@ func TestMariaDB(t *testing.T) {
 		},
 	}
 
-	probedTables := (&MariaDB{}).ProbeTables(db)
+	probedTables, err := (&MariaDB{}).ProbeTables(context.Background(), db, ``)
+	if err != nil {
+		t.Fatal(err)
+	}
 	foundTables := make(map[string]bool)
 	for _, table := range probedTables {
 		foundTables[table.Name] = true
@@ -81,7 +89,7 @@ func TestMariaDB(t *testing.T) {
 		t.Error(`Table "teachers" not found`)
 	}
 
-	b := bartlett.Bartlett{db, &MariaDB{}, tables, dummyUserProvider}
+	b := bartlett.Bartlett{DB: db, Driver: &MariaDB{}, Tables: tables, Users: dummyUserProvider}
 
 	routes := b.Routes()
 	testSimpleGetAll(t, routes)
@@ -170,3 +178,135 @@ func testSimpleGetAll(t *testing.T, routes []bartlett.Route) {
 		t.Errorf(`Expected first student to have age 18 but got %d instead`, testStudents[0].Age)
 	}
 }
+
+func TestColumnTypes(t *testing.T) {
+	if typeLength(`varchar(40)`) != 40 || typeLength(`CHAR(2)`) != 2 || typeLength(`int(11)`) != 0 {
+		t.Errorf(`Expected lengths only for character types`)
+	}
+
+	values := enumValues(`enum('a','it''s','c,d')`)
+	if !reflect.DeepEqual(values, []string{`a`, `it's`, `c,d`}) {
+		t.Errorf(`Expected [a it's c,d] but got %v`, values)
+	}
+	if enumValues(`varchar(10)`) != nil {
+		t.Errorf(`Expected no values for a varchar`)
+	}
+	if q := qualified("school.my `table`"); q != "`school`.`my ``table```" {
+		t.Errorf(`Expected the schema and table to be quoted separately but got %s`, q)
+	}
+}
+
+// TestTypeMatrix scans a value of every type that mysqlTypeToGo knows, as the MySQL driver would return it,
+// and checks the JSON it becomes. Each type is also checked with a NULL.
+func TestTypeMatrix(t *testing.T) {
+	cases := []struct {
+		dbType   string
+		value    driver.Value
+		expected string
+	}{
+		{`BIT`, []byte{0, 5}, `5`},
+		{`BLOB`, []byte(`hi`), `"aGk="`},
+		{`TEXT`, []byte(`hi`), `"hi"`},
+		{`DATE`, []byte(`2020-01-02`), `"2020-01-02"`},
+		{`DATETIME`, []byte(`2020-01-02 03:04:05`), `"2020-01-02 03:04:05"`},
+		{`DECIMAL`, []byte(`10.50`), `"10.50"`},
+		{`DOUBLE`, float64(1.5), `1.5`},
+		{`ENUM`, []byte(`a`), `"a"`},
+		{`FLOAT`, float32(2.5), `2.5`},
+		{`GEOMETRY`, []byte{1}, `"AQ=="`},
+		{`MEDIUMINT`, int64(16777215), `16777215`},
+		{`JSON`, []byte(`{"a":1}`), `"{\"a\":1}"`},
+		{`INT`, int64(4294967295), `4294967295`},
+		{`LONGTEXT`, []byte(`hi`), `"hi"`},
+		{`LONGBLOB`, []byte(`hi`), `"aGk="`},
+		{`BIGINT`, int64(-9223372036854775808), `-9223372036854775808`},
+		{`MEDIUMTEXT`, []byte(`hi`), `"hi"`},
+		{`MEDIUMBLOB`, []byte(`hi`), `"aGk="`},
+		{`CHAR`, []byte(`hi`), `"hi"`},
+		{`BINARY`, []byte(`hi`), `"aGk="`},
+		{`VARCHAR`, []byte(`hi`), `"hi"`},
+		{`VARBINARY`, []byte(`hi`), `"aGk="`},
+		{`TIME`, []byte(`03:04:05`), `"03:04:05"`},
+		{`TIMESTAMP`, []byte(`2020-01-02 03:04:05`), `"2020-01-02 03:04:05"`},
+		{`SMALLINT`, int64(65535), `65535`},
+		{`SET`, []byte(`a,b`), `"a,b"`},
+		{`TINYINT`, int64(255), `255`},
+		{`TINYBLOB`, []byte(`hi`), `"aGk="`},
+		{`YEAR`, int64(2020), `2020`},
+		{`TINYTEXT`, []byte(`hi`), `"hi"`},
+	}
+
+	for _, c := range cases {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		mock.ExpectQuery(`SELECT`).WillReturnRows(sqlmock.NewRows([]string{`v`}).AddRow(c.value).AddRow(nil))
+		rows, err := db.Query(`SELECT v`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp := httptest.NewRecorder()
+		err = marshalRows(rows, resp, []resultColumn{{name: `v`, dbType: c.dbType, nullable: true}}, bartlett.Encoding{})
+		if err != nil {
+			t.Errorf(`Expected %s to marshal but got %v`, c.dbType, err)
+		}
+		expected := `[{"v":` + c.expected + `},{"v":null}]`
+		if resp.Body.String() != expected {
+			t.Errorf(`Expected %s for %s but got %s`, expected, c.dbType, resp.Body.String())
+		}
+		db.Close()
+	}
+}
+
+func TestNotNullColumn(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mock.ExpectQuery(`SELECT`).WillReturnRows(sqlmock.NewRows([]string{`v`}).AddRow(int64(7)))
+	rows, err := db.Query(`SELECT v`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoders := &bartlett.Encoders{}
+	encoders.RegisterType(`INT`, func(v interface{}) (interface{}, error) {
+		return v.(int64) * 2, nil
+	})
+	resp := httptest.NewRecorder()
+	err = marshalRows(rows, resp, []resultColumn{{name: `v`, dbType: `INT`}}, bartlett.Encoding{Encoders: encoders})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Body.String() != `[{"v":14}]` {
+		t.Errorf(`Expected [{"v":14}] but got %s`, resp.Body.String())
+	}
+}
+
+// Older servers have no table_name in information_schema.check_constraints; the table must still get its columns.
+func TestGetColumnsWithoutCheckConstraints(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mock.ExpectQuery("SHOW COLUMNS FROM `students`").
+		WillReturnRows(sqlmock.NewRows([]string{`Field`, `Type`, `Null`, `Key`, `Default`, `Extra`}).
+			AddRow(`id`, `int(11)`, `NO`, `PRI`, nil, `auto_increment`).
+			AddRow(`data`, `longtext`, `YES`, ``, nil, ``))
+	mock.ExpectQuery(`FROM information_schema.key_column_usage`).
+		WillReturnRows(sqlmock.NewRows([]string{`constraint_name`, `column_name`, `table`, `column`}))
+	mock.ExpectQuery(`FROM information_schema.check_constraints`).
+		WillReturnError(errors.New(`Unknown column 'table_name' in 'where clause'`))
+
+	columns, err := (&MariaDB{}).GetColumns(context.Background(), db, bartlett.Table{Name: `students`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(columns) != 2 || columns[1].Name != `data` || columns[1].Type != `longtext` {
+		t.Errorf(`Expected id and data as text but got %+v`, columns)
+	}
+}
@@ -0,0 +1,213 @@
+package bartlett
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/buger/jsonparser"
+	"net/http"
+	"sort"
+)
+
+// A relation lets rows of another table be nested in a POST body, under that table's name.
+// Column is the other table's foreign key and References the column of this table it points to,
+// blank for the primary key.
+type relation struct {
+	Table      string
+	Column     string
+	References string
+}
+
+// linkRelations gives each table the relations of the tables whose foreign keys point to it.
+// A table with several foreign keys to the same parent is only linked through the first of them,
+// and a relation never hides a column of the same name.
+func linkRelations(tables map[string]Table) {
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names) // Map order is random; the links should not be.
+
+	for _, name := range names {
+		child := tables[name]
+		for _, col := range child.columnInfo {
+			if col.References == nil {
+				continue
+			}
+			parent, ok := tables[col.References.Table]
+			if _, linked := parent.relation(child.Name); !ok || linked || sliceContains(parent.columns, child.Name) {
+				continue
+			}
+			parent.relations = append(parent.relations, relation{
+				Table:      child.Name,
+				Column:     col.Name,
+				References: col.References.Column,
+			})
+			tables[parent.Name] = parent
+		}
+	}
+}
+
+func (t Table) relation(name string) (relation, bool) {
+	for _, rel := range t.relations {
+		if rel.Table == name {
+			return rel, true
+		}
+	}
+	return relation{}, false
+}
+
+// An insertion is what a POST has written so far, to be announced once its transaction commits.
+type insertion struct {
+	captured []*AuditRecord
+	tables   []string
+}
+
+// insertRow inserts one row into t and then the rows nested in it.
+// A failed INSERT of the row itself is returned as rowErr, so that the POST can go on with its other rows.
+// Any other error fails the whole request, with a status chosen as hookError would.
+func (b Bartlett) insertRow(ctx context.Context, tx *sql.Tx, t Table, r *http.Request, userID interface{}, row []byte, ins *insertion) (rowID interface{}, rowErr, err error) {
+	if t.IDColumn.Name != `` {
+		rowID = t.IDColumn.Generator()
+	}
+	managed := b.managedValues(t, OpInsert, r)
+
+	call := &Call{Operation: OpInsert, Table: t, Request: r, UserID: userID, Body: row, Tx: tx}
+	call.Insert = t.prepareInsert(row, userID, rowID, managed)
+	err = t.before(call, func(c *Call) error {
+		c.Insert = t.prepareInsert(c.Body, c.UserID, rowID, managed)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, withStatus(http.StatusForbidden, err)
+	}
+	audit, err := b.captureBefore(ctx, tx, call)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := call.Insert.RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		return nil, err, nil
+	}
+
+	if rowID == nil {
+		rowID, err = res.LastInsertId()
+		if err != nil {
+			return nil, err, nil
+		}
+	}
+
+	call.RowsAffected, _ = res.RowsAffected()
+	call.IDs = []interface{}{rowID}
+	err = t.after(call)
+	if err == nil {
+		err = b.captureAfter(ctx, tx, call, audit)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	ins.captured = append(ins.captured, audit)
+	if !sliceContains(ins.tables, t.Name) {
+		ins.tables = append(ins.tables, t.Name)
+	}
+
+	return rowID, nil, b.insertNested(ctx, tx, t, r, call.Body, rowID, ins)
+}
+
+// insertNested inserts the rows nested in row under the names of t's relations, pointing their foreign keys at it.
+// Each nested table's own Writable, UserID, schema and hooks apply.
+func (b Bartlett) insertNested(ctx context.Context, tx *sql.Tx, t Table, r *http.Request, row []byte, rowID interface{}, ins *insertion) error {
+	for _, rel := range t.relations {
+		nested, dataType, _, err := jsonparser.Get(row, rel.Table)
+		if err != nil || dataType == jsonparser.Null {
+			continue
+		}
+		child, ok := b.state.table(rel.Table)
+		if !ok {
+			continue
+		}
+		if !child.Writable {
+			return StatusError{http.StatusMethodNotAllowed, fmt.Errorf(`table %s is read-only`, child.Name)}
+		}
+		switch dataType {
+		case jsonparser.Object:
+			nested = append(append([]byte{'['}, nested...), ']')
+		case jsonparser.Array:
+		default:
+			return StatusError{http.StatusBadRequest, fmt.Errorf(`%s should be an array or an object`, rel.Table)}
+		}
+
+		childUser, err := b.writeUser(child, r)
+		if err != nil {
+			return StatusError{http.StatusForbidden, err}
+		}
+		key, err := parentKey(t, rel, row, rowID)
+		if err != nil {
+			return StatusError{http.StatusBadRequest, err}
+		}
+
+		var rows [][]byte
+		_, err = jsonparser.ArrayEach(nested, func(childRow []byte, dataType jsonparser.ValueType, offset int, _ error) {
+			if dataType == jsonparser.Object {
+				childRow, _ = jsonparser.Set(append([]byte(nil), childRow...), key, rel.Column)
+			}
+			rows = append(rows, childRow)
+		})
+		if err != nil {
+			return StatusError{http.StatusBadRequest, err}
+		}
+
+		err = child.validatePayload(r, append(append([]byte{'['}, bytes.Join(rows, []byte{','})...), ']'), b.strict(r))
+		var invalid *ValidationError
+		if errors.As(err, &invalid) {
+			for i := range invalid.Fields {
+				invalid.Fields[i].Path = `/` + rel.Table + invalid.Fields[i].Path
+			}
+			return StatusError{http.StatusUnprocessableEntity, err}
+		}
+
+		for _, childRow := range rows {
+			_, rowErr, err := b.insertRow(ctx, tx, child, r, childUser, childRow, ins)
+			if rowErr != nil {
+				return StatusError{http.StatusBadRequest, fmt.Errorf(`%s: %v`, rel.Table, rowErr)}
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// parentKey is the JSON value a nested row's foreign key takes: the new row's ID, or another of its columns.
+// A primary key given in the body wins over LastInsertId, which means nothing for keys the database didn't assign.
+func parentKey(t Table, rel relation, row []byte, rowID interface{}) ([]byte, error) {
+	column := rel.References
+	if column == `` {
+		column = t.primaryKey()
+	}
+	val, dataType, _, err := jsonparser.Get(row, column)
+	if column == t.primaryKey() && (t.IDColumn.Name != `` || err != nil) {
+		return json.Marshal(rowID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf(`%s needs %s to be set`, rel.Table, column)
+	}
+	if dataType == jsonparser.String {
+		val = append(append([]byte{'"'}, val...), '"') // Get strips the quotes but leaves the escapes.
+	}
+	return val, nil
+}
+
+// withStatus gives err a status code unless it already has one.
+func withStatus(status int, err error) error {
+	var statusErr StatusError
+	if errors.As(err, &statusErr) {
+		return err
+	}
+	return StatusError{status, err}
+}
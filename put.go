@@ -0,0 +1,249 @@
+package bartlett
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	sqrl "github.com/Masterminds/squirrel"
+	"github.com/buger/jsonparser"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// handlePut replaces the row named by its primary key, or creates it with that key if it doesn't exist yet.
+// The key comes from the item route, an `eq` filter on the key column, or the body, in that order.
+// Columns left out of the body are reset to their defaults, or NULL. Repeating a PUT leaves the row as it was.
+func (b Bartlett) handlePut(t Table, w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	status, userID, err := b.validateWrite(t, r, body)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+
+	key := t.primaryKey()
+	if key == `` {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf(`table %s has no single-column primary key`, t.Name))
+		return
+	}
+	r, id, err := putKey(t, r, body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	body = t.withoutKey(body)
+
+	ctx, cancel := b.context(t, r)
+	defer cancel()
+
+	tx, err := b.begin(ctx, r)
+	if err != nil {
+		queryError(ctx, w, err)
+		return
+	}
+	defer rollback(r, tx)
+
+	existing, err := scanRows(ctx, tx, sqrl.Select(t.quote(key)).From(t.sqlName()).Where(sqrl.Eq{t.quote(key): id}))
+	if err != nil {
+		queryError(ctx, w, err)
+		return
+	}
+	visible, err := scanRows(ctx, tx, b.targetRows(t, r, t.quote(key)))
+	if err != nil {
+		queryError(ctx, w, err)
+		return
+	}
+
+	_, _, conditional := ifMatch(r)
+	switch {
+	case len(existing) > 0 && r.Header.Get(`If-None-Match`) == `*`:
+		writeError(w, http.StatusPreconditionFailed, errors.New(`row already exists`))
+	case len(visible) > 0:
+		b.replaceRow(ctx, t, w, r, tx, userID, body)
+	case len(existing) > 0:
+		writeError(w, http.StatusConflict, errors.New(`row exists but may not be replaced`))
+	case conditional:
+		writeError(w, http.StatusPreconditionFailed, errPrecondition)
+	case t.IDColumn.Name != ``:
+		writeError(w, http.StatusNotFound, fmt.Errorf(`row not found; %s is assigned by the server`, key))
+	default:
+		b.createRow(ctx, t, w, r, tx, userID, body, id)
+	}
+}
+
+// putKey finds the primary key a PUT addresses and returns the request as if it had come through the item route.
+func putKey(t Table, r *http.Request, body []byte) (*http.Request, string, error) {
+	key := t.primaryKey()
+	var fromBody string
+	val, dataType, _, err := jsonparser.Get(body, key)
+	if err == nil && dataType != jsonparser.Null {
+		fromBody = string(val)
+		if dataType == jsonparser.String {
+			fromBody, _ = jsonparser.ParseString(val)
+		}
+	}
+
+	id, ok := itemID(r)
+	if filters := r.URL.Query()[key]; !ok && len(filters) == 1 && strings.HasPrefix(filters[0], `eq.`) {
+		id, ok = strings.TrimPrefix(filters[0], `eq.`), true
+		query := r.URL.Query()
+		query.Del(key) // The item route filters on the key instead.
+		r = r.Clone(r.Context())
+		r.URL.RawQuery = query.Encode()
+	}
+	if !ok && fromBody != `` {
+		id, ok = fromBody, true
+	}
+
+	switch {
+	case !ok:
+		return r, ``, fmt.Errorf(`PUT needs the row's %s in the URL or the body`, key)
+	case fromBody != `` && fromBody != id:
+		return r, ``, fmt.Errorf(`%s in the body does not match the URL`, key)
+	}
+
+	return withItem(r, id), id, nil
+}
+
+// buildReplace is an UPDATE that sets every writable column, to its default or NULL if the body leaves it out.
+func (b Bartlett) buildReplace(t Table, r *http.Request, userID interface{}, body []byte) (sqrl.UpdateBuilder, error) {
+	query, err := b.buildUpdate(t, r, userID, body)
+	if err != nil {
+		return query, err
+	}
+
+	writable := t.validWriteColumns()
+	for _, col := range t.columnInfo {
+		if col.Name == t.primaryKey() || !sliceContains(writable, col.Name) {
+			continue
+		}
+		if _, _, _, err := jsonparser.Get(body, col.Name); err == nil {
+			continue
+		}
+		if col.Default != `` {
+			query = query.Set(t.quote(col.Name), sqrl.Expr(col.Default))
+		} else {
+			query = query.Set(t.quote(col.Name), nil)
+		}
+	}
+
+	return query, nil
+}
+
+func (b Bartlett) replaceRow(ctx context.Context, t Table, w http.ResponseWriter, r *http.Request, tx *sql.Tx, userID interface{}, body []byte) {
+	query, err := b.buildReplace(t, r, userID, body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	call := &Call{Operation: OpUpdate, Table: t, Request: r, UserID: userID, Body: body, Update: query}
+	err = t.before(call, func(c *Call) (err error) {
+		c.Update, err = b.buildReplace(t, r, c.UserID, c.Body)
+		return err
+	})
+	if err != nil {
+		hookError(w, http.StatusForbidden, err)
+		return
+	}
+
+	matched, err := b.checkIfMatch(ctx, tx, call)
+	if err != nil {
+		queryError(ctx, w, err)
+		return
+	}
+	if !matched {
+		writeError(w, http.StatusPreconditionFailed, errPrecondition)
+		return
+	}
+
+	audit, err := b.captureBefore(ctx, tx, call)
+	if err != nil {
+		queryError(ctx, w, err)
+		return
+	}
+
+	res, err := call.Update.RunWith(tx).ExecContext(ctx)
+	if err != nil {
+		queryError(ctx, w, err)
+		return
+	}
+
+	b.finishWrite(ctx, call, tx, res, audit, w)
+}
+
+// createRow inserts the row a PUT named but didn't find, and responds with 201 Created.
+func (b Bartlett) createRow(ctx context.Context, t Table, w http.ResponseWriter, r *http.Request, tx *sql.Tx, userID interface{}, body []byte, id string) {
+	row, err := jsonparser.Set(body, []byte(strconv.Quote(id)), t.primaryKey())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	managed := b.managedValues(t, OpInsert, r)
+	call := &Call{Operation: OpInsert, Table: t, Request: r, UserID: userID, Body: row, Tx: tx}
+	call.Insert = t.prepareInsert(row, userID, nil, managed)
+	err = t.before(call, func(c *Call) error {
+		c.Insert = t.prepareInsert(c.Body, c.UserID, nil, managed)
+		return nil
+	})
+	if err != nil {
+		hookError(w, http.StatusForbidden, err)
+		return
+	}
+
+	audit, err := b.captureBefore(ctx, tx, call)
+	if err != nil {
+		queryError(ctx, w, err)
+		return
+	}
+	res, err := call.Insert.RunWith(tx).ExecContext(ctx)
+	if err != nil && b.createdConcurrently(ctx, t, id) {
+		if r.Header.Get(`If-None-Match`) == `*` {
+			writeError(w, http.StatusPreconditionFailed, errors.New(`row already exists`))
+		} else {
+			writeError(w, http.StatusConflict, errors.New(`row was created by a concurrent request`))
+		}
+		return
+	}
+	if err != nil {
+		queryError(ctx, w, err)
+		return
+	}
+
+	call.RowsAffected, _ = res.RowsAffected()
+	call.IDs = []interface{}{id}
+	err = t.after(call)
+	if err != nil {
+		hookError(w, http.StatusInternalServerError, err)
+		return
+	}
+	err = b.captureAfter(ctx, tx, call, audit)
+	if err != nil {
+		queryError(ctx, w, err)
+		return
+	}
+
+	err = commit(r, tx, func() {
+		b.Cache.invalidate(t.Name)
+		b.publish(audit)
+	})
+	if err != nil {
+		queryError(ctx, w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// createdConcurrently reports whether a row with the key a PUT failed to insert exists after all, because another
+// request created it after the PUT looked. The row is looked for outside the PUT's transaction, which can't see it.
+func (b Bartlett) createdConcurrently(ctx context.Context, t Table, id string) bool {
+	key := t.quote(t.primaryKey())
+	var found interface{}
+	err := sqrl.Select(key).From(t.sqlName()).Where(sqrl.Eq{key: id}).RunWith(b.DB).QueryRowContext(ctx).Scan(&found)
+	return err == nil
+}
@@ -0,0 +1,168 @@
+package bartlett
+
+import (
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPut(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	b := Bartlett{
+		DB:     db,
+		Driver: dummyDriver{},
+		Tables: []Table{
+			{Name: `students`, Writable: true},
+		},
+		Users: dummyUserProvider,
+	}
+	handler := b.Handler()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM students WHERE id = \?`).WithArgs(`1`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`}).AddRow(1))
+	mock.ExpectQuery(`SELECT id FROM students WHERE id = \?`).WithArgs(`1`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`}).AddRow(1))
+	mock.ExpectExec(`UPDATE students SET name = \?, a = \?, b = \? WHERE id = \?`).
+		WithArgs([]uint8(`todd`), nil, nil, `1`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPut, `/students/1`, strings.NewReader(`{"name":"todd"}`)))
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM students WHERE id = \?`).WithArgs(`2`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`}))
+	mock.ExpectQuery(`SELECT id FROM students WHERE id = \?`).WithArgs(`2`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`}))
+	mock.ExpectExec(`INSERT INTO students \(name,id\) VALUES \(\?,\?\)`).
+		WithArgs(`todd`, `2`).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPut, `/students?id=eq.2`, strings.NewReader(`{"name":"todd"}`)))
+	if resp.Code != http.StatusCreated {
+		t.Errorf(`Expected "201" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM students WHERE id = \?`).WithArgs(`3`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`}).AddRow(3))
+	mock.ExpectQuery(`SELECT id FROM students WHERE id = \?`).WithArgs(`3`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`}).AddRow(3))
+	mock.ExpectRollback()
+	req := httptest.NewRequest(http.MethodPut, `/students`, strings.NewReader(`{"id":3,"name":"todd"}`))
+	req.Header.Set(`If-None-Match`, `*`)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusPreconditionFailed {
+		t.Errorf(`Expected "412" for If-None-Match on an existing row but got %d for status code`, resp.Code)
+	}
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPut, `/students/1`, strings.NewReader(`{"id":2,"name":"todd"}`)))
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf(`Expected "400" for mismatched keys but got %d for status code`, resp.Code)
+	}
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPut, `/students`, strings.NewReader(`{"name":"todd"}`)))
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf(`Expected "400" without a key but got %d for status code`, resp.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPutGeneratedID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	b := Bartlett{
+		DB:     db,
+		Driver: dummyDriver{},
+		Tables: []Table{
+			{Name: `students`, Writable: true, IDColumn: IDSpec{Name: `id`, Generator: func() interface{} { return 9 }}},
+		},
+		Users: dummyUserProvider,
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM students WHERE id = \?`).WithArgs(`5`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`}))
+	mock.ExpectQuery(`SELECT id FROM students WHERE id = \?`).WithArgs(`5`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`}))
+	mock.ExpectRollback()
+	resp := httptest.NewRecorder()
+	b.Handler().ServeHTTP(resp, httptest.NewRequest(http.MethodPut, `/students/5`, strings.NewReader(`{"name":"todd"}`)))
+	if resp.Code != http.StatusNotFound {
+		t.Errorf(`Expected "404" when the server assigns keys but got %d for status code`, resp.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPutConcurrentCreate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	b := Bartlett{
+		DB:     db,
+		Driver: dummyDriver{},
+		Tables: []Table{
+			{Name: `students`, Writable: true},
+		},
+		Users: dummyUserProvider,
+	}
+	handler := b.Handler()
+
+	for _, tc := range []struct {
+		ifNoneMatch string
+		status      int
+	}{
+		{``, http.StatusConflict},
+		{`*`, http.StatusPreconditionFailed},
+	} {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT id FROM students WHERE id = \?`).WithArgs(`2`).
+			WillReturnRows(sqlmock.NewRows([]string{`id`}))
+		mock.ExpectQuery(`SELECT id FROM students WHERE id = \?`).WithArgs(`2`).
+			WillReturnRows(sqlmock.NewRows([]string{`id`}))
+		mock.ExpectExec(`INSERT INTO students \(name,id\) VALUES \(\?,\?\)`).
+			WillReturnError(errors.New(`UNIQUE constraint failed: students.id`))
+		mock.ExpectQuery(`SELECT id FROM students WHERE id = \?`).WithArgs(`2`).
+			WillReturnRows(sqlmock.NewRows([]string{`id`}).AddRow(2))
+		mock.ExpectRollback()
+		req := httptest.NewRequest(http.MethodPut, `/students/2`, strings.NewReader(`{"name":"todd"}`))
+		if tc.ifNoneMatch != `` {
+			req.Header.Set(`If-None-Match`, tc.ifNoneMatch)
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		if resp.Code != tc.status {
+			t.Errorf(`Expected "%d" when another request created the row but got %d for status code with body %s`, tc.status, resp.Code, resp.Body.String())
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
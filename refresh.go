@@ -0,0 +1,141 @@
+package bartlett
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// tableState holds the table metadata shared by every handler generated from a Bartlett.
+// Refresh builds a new map and swaps it in whole, so a request always sees one consistent version of a table.
+type tableState struct {
+	mu     sync.RWMutex
+	tables map[string]Table
+	routes map[string]string
+	probes []ProbeOptions
+
+	refreshing sync.Mutex // Held for all of Refresh, so that a slower reload can't replace the result of a newer one.
+}
+
+func (s *tableState) table(name string) (Table, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.tables[name]
+	return t, ok
+}
+
+// routed finds the table served under a path segment.
+func (s *tableState) routed(route string) (Table, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.tables[s.routes[route]]
+	return t, ok
+}
+
+func (s *tableState) all() map[string]Table {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tables
+}
+
+// addProbe remembers a ProbeTables call for Refresh to repeat.
+func (s *tableState) addProbe(opts ProbeOptions) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.probes = append(s.probes, opts)
+}
+
+// probeOptions returns a copy of the ProbeTables calls made so far.
+func (s *tableState) probeOptions() []ProbeOptions {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]ProbeOptions(nil), s.probes...)
+}
+
+// init prepares the shared state. Call it from the pointer-receiver entry points before any handler copies b.
+func (b *Bartlett) init() *tableState {
+	if b.state == nil {
+		b.state = &tableState{tables: make(map[string]Table)}
+	}
+	return b.state
+}
+
+// Refresh reloads the columns of every table and re-runs any ProbeTables calls so that new tables appear.
+// The new metadata replaces the old in a single step and is safe to call while requests are being served.
+// Concurrent calls run one after another.
+// A table whose columns cannot be read keeps its previous columns; the first such error is returned.
+func (b *Bartlett) Refresh(ctx context.Context) error {
+	state := b.init()
+	state.refreshing.Lock()
+	defer state.refreshing.Unlock()
+
+	old := state.all()
+	var firstErr error
+
+	tables := make(map[string]Table, len(b.Tables))
+	routes := make(map[string]string, len(b.Tables))
+	load := func(t Table) {
+		columns, err := b.Driver.GetColumns(ctx, b.DB, t)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			if prev, ok := old[t.Name]; ok {
+				t.columns, t.columnInfo = prev.columns, prev.columnInfo
+			}
+		} else {
+			t.setColumns(columns)
+		}
+		t.driver = b.Driver
+		tables[t.Name] = t
+		if _, taken := routes[t.route()]; !taken {
+			routes[t.route()] = t.Name // Listed tables come first, so they win over probed ones.
+		}
+	}
+
+	for _, t := range b.Tables {
+		load(t)
+	}
+
+	for _, opts := range state.probeOptions() {
+		probed, err := b.probe(ctx, opts, func(name string) bool {
+			_, ok := tables[name]
+			return ok
+		})
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		for _, t := range probed {
+			load(t)
+		}
+	}
+
+	linkRelations(tables)
+
+	state.mu.Lock()
+	state.tables = tables
+	state.routes = routes
+	state.mu.Unlock()
+
+	return firstErr
+}
+
+// Watch calls Refresh every interval until ctx is done. Errors are logged rather than returned.
+// Run it in its own goroutine: `go b.Watch(ctx, time.Minute)`.
+func (b *Bartlett) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := b.Refresh(ctx)
+			if err != nil {
+				log.Println(err.Error())
+			}
+		}
+	}
+}
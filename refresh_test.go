@@ -0,0 +1,210 @@
+package bartlett
+
+import (
+	"context"
+	"database/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// migratingDriver reports whatever schema the test has most recently migrated to.
+type migratingDriver struct {
+	dummyDriver
+	mu      sync.Mutex
+	columns []Column
+	tables  []Table
+}
+
+func (d *migratingDriver) GetColumns(context.Context, *sql.DB, Table) ([]Column, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.columns, nil
+}
+
+func (d *migratingDriver) ProbeTables(context.Context, *sql.DB, string) ([]Table, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.tables, nil
+}
+
+func (d *migratingDriver) migrate(columns []Column, tables []Table) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.columns = columns
+	d.tables = tables
+}
+
+func TestRefreshColumns(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	driver := &migratingDriver{columns: []Column{{Name: `id`}}}
+	b := Bartlett{
+		DB:     db,
+		Driver: driver,
+		Tables: []Table{
+			{Name: `students`, Writable: true},
+		},
+		Users: dummyUserProvider,
+	}
+
+	routes := b.Routes()
+
+	req, err := http.NewRequest(http.MethodPatch, `https://example.com/students?grade=eq.90`, strings.NewReader(`{"grade":91}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := httptest.NewRecorder()
+	routes[0].Handler(resp, req)
+	if resp.Code == http.StatusOK {
+		t.Fatalf(`Expected filter on unknown column "grade" to be rejected but got %d`, resp.Code)
+	}
+
+	driver.migrate([]Column{{Name: `id`}, {Name: `grade`}}, nil)
+	err = b.Refresh(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE students SET grade = \? WHERE grade = \?`).
+		WithArgs([]uint8(`91`), `90`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	req, err = http.NewRequest(http.MethodPatch, `https://example.com/students?grade=eq.90`, strings.NewReader(`{"grade":91}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = httptest.NewRecorder()
+	routes[0].Handler(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestHandlerNewTables(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	driver := &migratingDriver{columns: []Column{{Name: `id`}}, tables: []Table{{Name: `students`}}}
+	b := Bartlett{DB: db, Driver: driver, Users: dummyUserProvider}
+	err = b.ProbeTables(context.Background(), ProbeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := b.Handler()
+
+	req, err := http.NewRequest(http.MethodGet, `https://example.com/teachers`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf(`Expected "404" but got %d for status code`, resp.Code)
+	}
+
+	driver.migrate([]Column{{Name: `id`}}, []Table{{Name: `students`}, {Name: `teachers`}})
+	err = b.Refresh(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery(`SELECT \* FROM teachers`).WillReturnRows(sqlmock.NewRows([]string{`id`}))
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRefreshConcurrent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.MatchExpectationsInOrder(false)
+	driver := &migratingDriver{columns: []Column{{Name: `id`}}}
+	b := Bartlett{DB: db, Driver: driver, Tables: []Table{{Name: `students`}}, Users: dummyUserProvider}
+	handler := b.Handler()
+
+	for i := 0; i < 10; i++ { // Expectations can't be added while queries run, so register them all first.
+		mock.ExpectQuery(`SELECT`).WillReturnRows(sqlmock.NewRows([]string{`id`}))
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodGet, `https://example.com/students?id=eq.1`, nil)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+		go func() {
+			defer wg.Done()
+			_ = b.Refresh(context.Background())
+		}()
+	}
+	wg.Wait()
+}
+
+// overlapDriver records how many GetColumns calls were ever in flight at once.
+type overlapDriver struct {
+	dummyDriver
+	mu       sync.Mutex
+	inFlight int
+	most     int
+}
+
+func (d *overlapDriver) GetColumns(context.Context, *sql.DB, Table) ([]Column, error) {
+	d.mu.Lock()
+	d.inFlight++
+	if d.inFlight > d.most {
+		d.most = d.inFlight
+	}
+	d.mu.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	d.mu.Lock()
+	d.inFlight--
+	d.mu.Unlock()
+	return []Column{{Name: `id`}}, nil
+}
+
+func TestRefreshSerialized(t *testing.T) {
+	driver := &overlapDriver{}
+	b := Bartlett{Driver: driver, Tables: []Table{{Name: `students`}}}
+	b.init()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = b.Refresh(context.Background())
+		}()
+	}
+	wg.Wait()
+
+	if driver.most != 1 {
+		t.Errorf(`Expected one Refresh at a time but saw %d at once`, driver.most)
+	}
+}
@@ -1,12 +1,18 @@
 package bartlett
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	sqrl "github.com/Masterminds/squirrel"
 	"github.com/buger/jsonparser"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 )
 
 type Route struct {
@@ -17,28 +23,95 @@ type Route struct {
 // Routes generates all of the paths and handlers for the tables specified in Bartlett.
 // Iterate this output to feed it into your web server, prefix or otherwise alter the route names,
 // and add filtering to the handler functions.
+// Handlers look up their table on every request, so columns picked up by Refresh take effect immediately.
+// Each table's path is its Route, or else its name.
+// The last route is `/batch`, unless a table of that name takes its place.
 func (b *Bartlett) Routes() []Route {
+	err := b.Refresh(context.Background())
+	if err != nil {
+		log.Println(err.Error())
+	}
+
 	routes := make([]Route, len(b.Tables))
 	for i, t := range b.Tables {
-		columns, err := b.Driver.GetColumns(b.DB, t)
-		if err != nil {
-			log.Println(err.Error())
-		} else {
-			t.columns = columns
-		}
 		routes[i] = Route{
-			Handler: b.handleRoute(t),
-			Path:    fmt.Sprintf(`/%s`, t.Name),
+			Handler: b.handleRoute(t.route()),
+			Path:    fmt.Sprintf(`/%s`, t.route()),
 		}
 	}
+	if _, ok := b.state.routed(`batch`); !ok {
+		routes = append(routes, Route{Handler: b.handleBatch, Path: `/batch`})
+	}
 
 	return routes
 }
 
-func (b Bartlett) handleRoute(t Table) func(http.ResponseWriter, *http.Request) {
+// Handler serves every table from a single http.Handler, routing `/<table>` by its Route, or else its name.
+// Single rows are addressed by primary key as `/<table>/<id>`, using IDColumn or the key reported by the Driver.
+// Unlike Routes, tables added by Refresh are served without registering anything new.
+// `POST /batch` runs a list of operations in a single transaction, unless a table named batch takes its place.
+// To mount it under a prefix, strip the prefix first: `http.Handle("/api/", http.StripPrefix("/api", b.Handler()))`.
+func (b *Bartlett) Handler() http.Handler {
+	err := b.Refresh(context.Background())
+	if err != nil {
+		log.Println(err.Error())
+	}
+
+	return http.HandlerFunc(b.serve)
+}
+
+// serve routes a request for `/<table>` or `/<table>/<id>` to its table.
+func (b Bartlett) serve(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.Trim(r.URL.Path, `/`), `/`, 2)
+	if _, ok := b.state.routed(`batch`); len(parts) == 1 && parts[0] == `batch` && !ok {
+		b.handleBatch(w, r)
+		return
+	}
+	if len(parts) == 2 {
+		r = withItem(r, parts[1])
+	}
+	b.handleRoute(parts[0])(w, r)
+}
+
+// handleRoute serves the table whose route is name.
+func (b Bartlett) handleRoute(name string) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set(`Content-Type`, `application/json`)
 
+		t, ok := b.state.routed(name)
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf(`table %s not found`, name))
+			return
+		}
+
+		if id, ok := itemID(r); ok {
+			if t.primaryKey() == `` {
+				writeError(w, http.StatusNotFound, fmt.Errorf(`table %s has no single-column primary key`, name))
+				return
+			}
+			if id == `` || r.Method == http.MethodPost {
+				writeError(w, http.StatusMethodNotAllowed, fmt.Errorf(`%s is not supported on a single row`, r.Method))
+				return
+			}
+		}
+
+		if err := t.checkSoftDelete(r); err != nil {
+			writeError(w, http.StatusForbidden, err)
+			return
+		}
+
+		if b.strict(r) {
+			if err := t.checkNames(r); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		if _, err := t.filters(r); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
 		switch r.Method {
 		case http.MethodGet:
 			b.handleGet(t, w, r)
@@ -48,6 +121,8 @@ func (b Bartlett) handleRoute(t Table) func(http.ResponseWriter, *http.Request)
 			b.handleDelete(t, w, r)
 		case http.MethodPatch:
 			b.handlePatch(t, w, r)
+		case http.MethodPut:
+			b.handlePut(t, w, r)
 		default:
 			w.WriteHeader(http.StatusNotImplemented)
 			return
@@ -57,83 +132,258 @@ func (b Bartlett) handleRoute(t Table) func(http.ResponseWriter, *http.Request)
 
 func (b Bartlett) handleDelete(t Table, w http.ResponseWriter, r *http.Request) {
 	if !t.Writable {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		_, _ = w.Write([]byte(fmt.Sprintf(`{"error": "Table %s is read-only"}`, t.Name)))
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf(`Table %s is read-only`, t.Name))
+		return
+	}
+
+	var err error
+	call := &Call{Operation: OpDelete, Table: t, Request: r}
+	if t.SoftDelete.Name != `` {
+		call.Update, err = b.buildSoftDelete(t, r)
+	} else {
+		call.Delete, err = b.buildDelete(t, r)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = t.before(call, nil)
+	if err != nil {
+		hookError(w, http.StatusForbidden, err)
 		return
 	}
 
-	query, err := b.buildDelete(t, r)
+	ctx, cancel := b.context(t, r)
+	defer cancel()
+
+	tx, err := b.begin(ctx, r)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(fmt.Sprintf(`{"error": "%s"}`, err.Error())))
+		queryError(ctx, w, err)
 		return
 	}
+	defer rollback(r, tx)
 
-	rows, err := query.RunWith(b.DB).Query()
+	matched, err := b.checkIfMatch(ctx, tx, call)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(fmt.Sprintf(`{"error": "%s"}`, err.Error())))
+		queryError(ctx, w, err)
+		return
+	}
+	if !matched {
+		writeError(w, http.StatusPreconditionFailed, errPrecondition)
+		return
+	}
+
+	audit, err := b.captureBefore(ctx, tx, call)
+	if err != nil {
+		queryError(ctx, w, err)
 		return
 	}
-	defer rows.Close()
 
-	err = b.Driver.MarshalResults(rows, w)
+	res, err := sqrl.ExecContextWith(ctx, tx, call.statement())
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(fmt.Sprintf(`{"error": "%s"}`, err.Error())))
+		queryError(ctx, w, err)
 		return
 	}
+
+	b.finishWrite(ctx, call, tx, res, audit, w)
 }
 
 func (b Bartlett) handleGet(t Table, w http.ResponseWriter, r *http.Request) {
+	if wantsSubscription(r) {
+		b.handleSubscribe(t, w, r)
+		return
+	}
+
 	query, err := b.buildSelect(t, r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(fmt.Sprintf(`{"error": "%s"}`, err.Error())))
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	call := &Call{Operation: OpSelect, Table: t, Request: r, Select: query}
+	err = t.before(call, nil)
+	if err != nil {
+		hookError(w, http.StatusForbidden, err)
 		return
 	}
 
-	rows, err := query.RunWith(b.DB).Query()
+	var (
+		key        string
+		generation uint64
+	)
+	_, batched := batchOf(r) // A batch reads its own uncommitted writes, which must not be cached.
+	cache := b.Cache != nil && !batched
+	if cache {
+		key, err = cacheKey(call, b.auditUser(r))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if resp, ok := b.Cache.get(key); ok {
+			err = t.after(call)
+			if err != nil {
+				hookError(w, http.StatusInternalServerError, err)
+				return
+			}
+			writeCacheable(w, r, t, resp)
+			return
+		}
+		generation = b.Cache.generation(t.Name)
+	}
+
+	ctx, cancel := b.context(t, r)
+	defer cancel()
+
+	rows, err := call.Select.RunWith(b.runner(r)).QueryContext(ctx)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(fmt.Sprintf(`{"error": "%s"}`, err.Error())))
+		queryError(ctx, w, err)
 		return
 	}
 	defer rows.Close()
 
-	err = b.Driver.MarshalResults(rows, w)
+	err = t.after(call)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(fmt.Sprintf(`{"error": "%s"}`, err.Error())))
+		hookError(w, http.StatusInternalServerError, err)
 		return
 	}
+
+	resp, err := b.renderGet(t, r, rows)
+	if errors.Is(err, errNoRow) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		queryError(ctx, w, err)
+		return
+	}
+
+	if cache {
+		resp.key = key
+		b.Cache.put(resp, generation)
+	}
+	writeCacheable(w, r, t, resp)
+}
+
+// finishWrite runs the After hooks for an UPDATE or DELETE, records it for the audit log and commits its transaction.
+func (b Bartlett) finishWrite(ctx context.Context, call *Call, tx *sql.Tx, res sql.Result, audit *AuditRecord, w http.ResponseWriter) {
+	status, err := call.written(tx, res)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+
+	err = call.Table.after(call)
+	if err != nil {
+		hookError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = b.captureAfter(ctx, tx, call, audit)
+	if err != nil {
+		queryError(ctx, w, err)
+		return
+	}
+
+	err = commit(call.Request, tx, func() {
+		b.Cache.invalidate(call.Table.Name)
+		b.publish(audit)
+	})
+	if err != nil {
+		queryError(ctx, w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// written records the result of the Call's UPDATE or DELETE. It fails the write with 412 if the write missed a row
+// that If-Match checked, since the row changed in between, and with 404 if an item route's row was not there.
+// Collection writes may touch no rows at all. Drivers that cannot count rows get the benefit of the doubt.
+func (c *Call) written(tx *sql.Tx, res sql.Result) (int, error) {
+	c.Tx = tx
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return http.StatusOK, nil
+	}
+	c.RowsAffected = affected
+
+	if c.versioned > 0 && affected != c.versioned {
+		return http.StatusPreconditionFailed, errPrecondition
+	}
+	if _, ok := itemID(c.Request); ok && affected == 0 {
+		return http.StatusNotFound, errors.New(`row not found`)
+	}
+
+	return http.StatusOK, nil
 }
 
 func (b Bartlett) handlePatch(t Table, w http.ResponseWriter, r *http.Request) {
 	body, _ := ioutil.ReadAll(r.Body)
+	if len(body) == 0 && restoring(r) {
+		body = []byte(`{}`) // A restore needs nothing more than its WHERE.
+	}
 	status, userID, err := b.validateWrite(t, r, body)
 	if err != nil {
-		w.WriteHeader(status)
-		_, _ = w.Write([]byte(fmt.Sprintf(`{"error": "%s"}`, err.Error())))
+		writeError(w, status, err)
+		return
+	}
+
+	if body[0] == '[' {
+		b.handleBulkPatch(t, w, r, body, userID)
 		return
 	}
 
 	query, err := b.buildUpdate(t, r, userID, body)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(fmt.Sprintf(`{"error": "%s"}`, err.Error())))
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	call := &Call{Operation: OpUpdate, Table: t, Request: r, UserID: userID, Body: body, Update: query}
+	err = t.before(call, func(c *Call) (err error) {
+		c.Update, err = b.buildUpdate(t, r, c.UserID, c.Body)
+		return err
+	})
+	if err != nil {
+		hookError(w, http.StatusForbidden, err)
 		return
 	}
 
-	_, err = query.RunWith(b.DB).Exec()
+	ctx, cancel := b.context(t, r)
+	defer cancel()
 
+	tx, err := b.begin(ctx, r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(fmt.Sprintf(`{"error": "%s"}`, err.Error())))
+		queryError(ctx, w, err)
 		return
 	}
+	defer rollback(r, tx)
 
-	w.WriteHeader(http.StatusOK)
+	matched, err := b.checkIfMatch(ctx, tx, call)
+	if err != nil {
+		queryError(ctx, w, err)
+		return
+	}
+	if !matched {
+		writeError(w, http.StatusPreconditionFailed, errPrecondition)
+		return
+	}
+
+	audit, err := b.captureBefore(ctx, tx, call)
+	if err != nil {
+		queryError(ctx, w, err)
+		return
+	}
+
+	res, err := call.Update.RunWith(tx).ExecContext(ctx)
+
+	if err != nil {
+		queryError(ctx, w, err)
+		return
+	}
+
+	b.finishWrite(ctx, call, tx, res, audit, w)
 }
 
 type postResult struct {
@@ -145,17 +395,33 @@ func (b Bartlett) handlePost(t Table, w http.ResponseWriter, r *http.Request) {
 	body, _ := ioutil.ReadAll(r.Body)
 	status, userID, err := b.validateWrite(t, r, body)
 	if err != nil {
-		w.WriteHeader(status)
-		_, _ = w.Write([]byte(fmt.Sprintf(`{"error": "%s"}`, err.Error())))
+		writeError(w, status, err)
 		return
 	}
 
-	tx, err := b.DB.Begin()
+	ctx, cancel := b.context(t, r)
+	defer cancel()
+
+	tx, err := b.begin(ctx, r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(fmt.Sprintf(`{"error": "%s"}`, err.Error())))
+		queryError(ctx, w, err)
+		return
+	}
+	defer rollback(r, tx)
+
+	hash := requestHash(t, body)
+	if b.replayPost(ctx, tx, w, r, hash) {
 		return
 	}
+	key := r.Header.Get(`Idempotency-Key`)
+	committed := false
+	if b.Idempotency != nil && key != `` {
+		defer func() {
+			if !committed {
+				b.Idempotency.Forget(key, b.auditUser(r)) // Lift the reservation Load may have made.
+			}
+		}()
+	}
 
 	result := postResult{
 		Errors:  make([]error, 0),
@@ -166,52 +432,76 @@ func (b Bartlett) handlePost(t Table, w http.ResponseWriter, r *http.Request) {
 		body = append([]byte{'['}, append(body, ']')...)
 	}
 
+	var abortErr error
+	ins := &insertion{}
 	_, err = jsonparser.ArrayEach(body, func(row []byte, dataType jsonparser.ValueType, offset int, err error) {
-		rowID := interface{}(nil)
-		if t.IDColumn.Name != `` {
-			rowID = t.IDColumn.Generator()
+		if abortErr != nil {
+			return // A hook, the audit log or a nested row has already failed the transaction.
 		}
-		query := t.prepareInsert(row, userID, rowID)
-		res, err := query.RunWith(tx).Exec()
+		rowID, rowErr, err := b.insertRow(ctx, tx, t, r, userID, row, ins)
 		if err != nil {
-			result.Errors = append(result.Errors, err)
+			abortErr = err
 			return
 		}
-
-		if rowID == nil {
-			rowID, err = res.LastInsertId()
-			if err != nil {
-				result.Errors = append(result.Errors, err)
-				return
-			}
+		if rowErr != nil {
+			result.Errors = append(result.Errors, rowErr)
+			return
 		}
-
 		result.Inserts = append(result.Inserts, rowID)
 	})
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(fmt.Sprintf(`{"error": "%s while parsing input"}`, err.Error())))
+		writeError(w, http.StatusInternalServerError, fmt.Errorf(`%s while parsing input`, err.Error()))
 		return
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(fmt.Sprintf(`{"error": "%s"}`, err.Error())))
+	if abortErr != nil {
+		hookError(w, http.StatusInternalServerError, abortErr)
+		return
+	}
+
+	if ctx.Err() != nil { // Every insert after the deadline fails the same way; report that instead of the row errors.
+		queryError(ctx, w, ctx.Err())
 		return
 	}
 
 	out, err := json.Marshal(result)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(fmt.Sprintf(`{"error": "%s"}`, err.Error())))
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
-
+	status = http.StatusOK
 	if len(result.Inserts) == 0 {
-		w.WriteHeader(http.StatusBadRequest)
+		status = http.StatusBadRequest
+	}
+
+	if b.Idempotency != nil && key != `` {
+		resp := IdempotentResponse{Hash: hash, Status: status, Body: out, Time: time.Now()}
+		err = b.Idempotency.Save(ctx, tx, key, b.auditUser(r), resp)
+		if err != nil {
+			rollback(r, tx)
+			if !b.replaySaved(ctx, w, r, hash) {
+				queryError(ctx, w, err)
+			}
+			return
+		}
+	}
+
+	err = commit(r, tx, func() {
+		for _, name := range ins.tables {
+			b.Cache.invalidate(name)
+		}
+		for _, rec := range ins.captured {
+			b.publish(rec)
+		}
+	})
+	if err != nil {
+		queryError(ctx, w, err)
+		return
 	}
+	committed = true
+
+	w.WriteHeader(status)
 	_, _ = w.Write(out)
 }
 
@@ -230,22 +520,68 @@ func (b Bartlett) validateWrite(t Table, r *http.Request, body []byte) (status i
 		return status, userID, err
 	}
 
-	if r.Method == http.MethodPatch && rune(body[0]) != '{' { // Updates are single value.
+	if r.Method == http.MethodPatch && rune(body[0]) != '{' && rune(body[0]) != '[' { // Updates are one object or an array of them.
 		status = http.StatusBadRequest
 		err = fmt.Errorf(`JSON data should be an object`)
 		return status, nil, err
 	}
 
-	if t.UserID != `` {
-		userID, err = b.Users(r)
-		if err != nil || userID == nil {
-			status = http.StatusForbidden
-			err = fmt.Errorf(`failed to generate userID: %v`, err)
-			return status, nil, err
-		}
-	} else {
-		userID = 0
+	if r.Method == http.MethodPut && rune(body[0]) != '{' { // A replacement is a single row.
+		status = http.StatusBadRequest
+		err = fmt.Errorf(`JSON data should be an object`)
+		return status, nil, err
+	}
+
+	userID, err = b.writeUser(t, r)
+	if err != nil {
+		return http.StatusForbidden, nil, err
+	}
+
+	err = t.validatePayload(r, body, b.strict(r))
+	if err != nil {
+		return http.StatusUnprocessableEntity, nil, err
 	}
 
 	return status, userID, err
 }
+
+// writeUser is the user ID written to a table's UserID column, which must be known before a table that has one
+// is written to or subscribed to.
+func (b Bartlett) writeUser(t Table, r *http.Request) (interface{}, error) {
+	if t.UserID == `` {
+		return 0, nil
+	}
+	userID, err := b.Users(r)
+	if err != nil || userID == nil {
+		return nil, fmt.Errorf(`failed to generate userID: %v`, err)
+	}
+
+	return userID, nil
+}
+
+// queryError reports a failed query.
+// Drivers disagree on what a cancelled statement returns, so the context decides whether the timeout fired.
+func queryError(ctx context.Context, w http.ResponseWriter, err error) {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		writeError(w, http.StatusGatewayTimeout, errors.New(`statement timeout exceeded`))
+		return
+	}
+
+	writeError(w, http.StatusInternalServerError, err)
+}
+
+// writeError emits err as a JSON object of the form {"error": "..."} with the given status code.
+// A ValidationError adds the problem with each field as "fields".
+func writeError(w http.ResponseWriter, status int, err error) {
+	var fields []FieldError
+	var invalid *ValidationError
+	if errors.As(err, &invalid) {
+		fields = invalid.Fields
+	}
+	out, _ := json.Marshal(struct {
+		Error  string       `json:"error"`
+		Fields []FieldError `json:"fields,omitempty"`
+	}{err.Error(), fields})
+	w.WriteHeader(status)
+	_, _ = w.Write(out)
+}
@@ -1,6 +1,8 @@
 package bartlett
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,6 +11,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestGetRoute(t *testing.T) {
@@ -100,9 +103,11 @@ func TestPatchRoute(t *testing.T) {
 
 	routes := b.Routes()
 
+	mock.ExpectBegin()
 	mock.ExpectExec(`UPDATE students SET name = \? WHERE id = \?`).
 		WithArgs([]uint8(`todd`), `15`).
 		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
 
 	req, err := http.NewRequest(http.MethodPatch, `https://example.com/students?id=eq.15`, strings.NewReader(`{"name":"todd"}`))
 	if err != nil {
@@ -310,11 +315,16 @@ func TestValidatePatch(t *testing.T) {
 	b := Bartlett{}
 	tbl := Table{Writable: true}
 	req := http.Request{Method: http.MethodPatch}
-	body := []byte(`[{"a":1}]`)
+	body := []byte(`"a"`)
 	status, _, _ := b.validateWrite(tbl, &req, body)
 	if status != http.StatusBadRequest {
 		t.Errorf(`Expected "400" but got %d for status code`, status)
 	}
+
+	status, _, _ = b.validateWrite(tbl, &req, []byte(`[{"id":1,"a":1}]`))
+	if status != http.StatusOK {
+		t.Errorf(`Expected "200" for a bulk update but got %d for status code`, status)
+	}
 }
 
 func TestValidatePost(t *testing.T) {
@@ -327,3 +337,209 @@ func TestValidatePost(t *testing.T) {
 		t.Errorf(`Expected "200" but got %d for status code`, status)
 	}
 }
+
+func TestGetTimeout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	b := Bartlett{
+		DB:     db,
+		Driver: dummyDriver{},
+		Tables: []Table{
+			{Name: `students`, Timeout: 10 * time.Millisecond},
+		},
+		Users:   dummyUserProvider,
+		Timeout: time.Minute,
+	}
+
+	routes := b.Routes()
+
+	mock.ExpectQuery(`SELECT \* FROM students`).
+		WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{`name`, `age`}))
+	req, err := http.NewRequest(http.MethodGet, `https://example.com/students`, strings.NewReader(``))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := httptest.NewRecorder()
+	routes[0].Handler(resp, req)
+	if resp.Code != http.StatusGatewayTimeout {
+		t.Errorf(`Expected "504" but got %d for status code`, resp.Code)
+	}
+	if !json.Valid(resp.Body.Bytes()) {
+		t.Errorf(`Expected valid JSON response but got %s`, resp.Body.String())
+	}
+}
+
+func TestPatchCancelled(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	b := Bartlett{
+		DB:     db,
+		Driver: dummyDriver{},
+		Tables: []Table{
+			{Name: `students`, Writable: true},
+		},
+		Users: dummyUserProvider,
+	}
+
+	routes := b.Routes()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE students`).
+		WillDelayFor(time.Second).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, `https://example.com/students?id=eq.15`, strings.NewReader(`{"name":"todd"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(10*time.Millisecond, cancel) // The client hangs up mid-query.
+	resp := httptest.NewRecorder()
+	routes[0].Handler(resp, req)
+	if resp.Code != http.StatusInternalServerError {
+		t.Errorf(`Expected "500" but got %d for status code`, resp.Code)
+	}
+}
+
+// rowDriver marshals result sets for real so that tests can see what a handler wrote.
+type rowDriver struct {
+	dummyDriver
+}
+
+func (d rowDriver) MarshalResults(rows *sql.Rows, w http.ResponseWriter, _ Encoding) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	out := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return err
+		}
+		row := make(map[string]interface{})
+		for i, col := range columns {
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			row[col] = values[i]
+		}
+		out = append(out, row)
+	}
+	body, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	return err
+}
+
+func TestHandlerItem(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	b := Bartlett{
+		DB:     db,
+		Driver: rowDriver{},
+		Tables: []Table{
+			{Name: `students`, Writable: true},
+		},
+		Users: dummyUserProvider,
+	}
+	handler := b.Handler()
+
+	mock.ExpectQuery(`SELECT \* FROM students WHERE id = \?`).
+		WithArgs(`42`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`, `name`}).AddRow(42, `todd`))
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, `/students/42`, nil))
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+	if resp.Body.String() != `{"id":42,"name":"todd"}` {
+		t.Errorf(`Expected a single object but got %s`, resp.Body.String())
+	}
+
+	mock.ExpectQuery(`SELECT \* FROM students WHERE id = \?`).
+		WithArgs(`43`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`, `name`}))
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, `/students/43`, nil))
+	if resp.Code != http.StatusNotFound {
+		t.Errorf(`Expected "404" but got %d for status code`, resp.Code)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE students SET name = \? WHERE id = \?`).
+		WithArgs([]uint8(`ned`), `43`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPatch, `/students/43`, strings.NewReader(`{"name":"ned"}`)))
+	if resp.Code != http.StatusNotFound {
+		t.Errorf(`Expected "404" but got %d for status code`, resp.Code)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM students WHERE id = \?`).
+		WithArgs(`42`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodDelete, `/students/42`, nil))
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, `/teachers/42`, nil))
+	if resp.Code != http.StatusNotFound {
+		t.Errorf(`Expected "404" for unknown table but got %d for status code`, resp.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestHandlerItemIDColumn(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	b := Bartlett{
+		DB:     db,
+		Driver: rowDriver{},
+		Tables: []Table{
+			{Name: `students`, IDColumn: IDSpec{Name: `name`, Generator: func() interface{} { return `x` }}},
+		},
+		Users: dummyUserProvider,
+	}
+	handler := b.Handler()
+
+	mock.ExpectQuery(`SELECT \* FROM students WHERE name = \?`).
+		WithArgs(`todd`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`, `name`}).AddRow(42, `todd`))
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, `/students/todd`, nil))
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
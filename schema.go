@@ -0,0 +1,284 @@
+package bartlett
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/buger/jsonparser"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A JSONSchema describes the rows a table accepts, in the subset of JSON Schema that Bartlett checks writes against:
+// type, properties, required, additionalProperties, maxLength, enum, minimum and maximum.
+// Bartlett generates one for each table from the Driver's column metadata. Set Table.Schema to use your own instead.
+type JSONSchema struct {
+	Type                 []string               `json:"type,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+	MaxLength            int                    `json:"maxLength,omitempty"`
+	Enum                 []interface{}          `json:"enum,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+}
+
+// A FieldError locates one problem with a write payload.
+// Path is a JSON Pointer into the request body, such as `/2/age` for the third row of an array.
+type FieldError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// A ValidationError is returned with 422 Unprocessable Entity when a payload does not match its table's schema.
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	return `payload does not match the table schema`
+}
+
+// JSONSchema returns the schema that writes to the named table are validated against.
+func (b *Bartlett) JSONSchema(table string) (*JSONSchema, bool) {
+	t, ok := b.init().table(table)
+	if !ok {
+		return nil, false
+	}
+	schema := t.jsonSchema(b.Strict)
+	return schema, schema != nil
+}
+
+// jsonSchema is Table.Schema if set, or else the schema generated from the columns the Driver reported.
+// Tables whose columns are unknown have no schema and are not validated.
+// A generated schema refuses keys that aren't columns only when closed, as strict requests are;
+// otherwise they are ignored like unknown query parameters.
+func (t Table) jsonSchema(closed bool) *JSONSchema {
+	if t.Schema != nil {
+		return t.Schema
+	}
+	if len(t.columnInfo) == 0 {
+		return nil
+	}
+
+	schema := &JSONSchema{Properties: make(map[string]*JSONSchema, len(t.columnInfo))}
+	if closed {
+		schema.AdditionalProperties = new(bool)
+	}
+	writable := t.validWriteColumns()
+	for _, col := range t.columnInfo {
+		if !sliceContains(writable, col.Name) {
+			schema.Properties[col.Name] = &JSONSchema{} // Accepted and then ignored, as the server fills it in.
+			continue
+		}
+		schema.Properties[col.Name] = columnSchema(col)
+		if col.NotNull && !col.HasDefault {
+			schema.Required = append(schema.Required, col.Name)
+		}
+	}
+	for _, name := range []string{t.UserID, t.IDColumn.Name, t.Version.Name, t.SoftDelete.Name} {
+		if _, ok := schema.Properties[name]; name != `` && !ok {
+			schema.Properties[name] = &JSONSchema{}
+		}
+	}
+	for _, col := range t.Managed {
+		if _, ok := schema.Properties[col.Name]; !ok {
+			schema.Properties[col.Name] = &JSONSchema{}
+		}
+	}
+	for _, rel := range t.relations {
+		schema.Properties[rel.Table] = &JSONSchema{Type: []string{`array`, `object`}} // Checked against its own table.
+	}
+
+	return schema
+}
+
+// columnSchema maps a column's database type onto JSON types. Types it doesn't recognize accept anything.
+func columnSchema(col Column) *JSONSchema {
+	schema := &JSONSchema{MaxLength: col.Length}
+	dbType := strings.ToLower(col.Type)
+	switch {
+	case dbType == `tinyint(1)` || strings.HasPrefix(dbType, `bool`):
+		schema.Type = []string{`boolean`, `integer`}
+	case isInteger(dbType):
+		schema.Type = []string{`integer`}
+	case strings.Contains(dbType, `dec`) || strings.Contains(dbType, `num`) || strings.Contains(dbType, `real`) ||
+		strings.Contains(dbType, `float`) || strings.Contains(dbType, `double`):
+		schema.Type = []string{`number`}
+	case strings.Contains(dbType, `char`) || strings.Contains(dbType, `text`) || strings.Contains(dbType, `clob`) ||
+		strings.HasPrefix(dbType, `enum`) || strings.HasPrefix(dbType, `set`) ||
+		strings.Contains(dbType, `date`) || strings.Contains(dbType, `time`):
+		schema.Type = []string{`string`}
+	}
+	for _, val := range col.Enum {
+		schema.Enum = append(schema.Enum, val)
+	}
+	if schema.Type != nil && !col.NotNull {
+		schema.Type = append(schema.Type, `null`)
+	}
+	if schema.Enum != nil && !col.NotNull {
+		schema.Enum = append(schema.Enum, nil)
+	}
+
+	return schema
+}
+
+// integerTypes are the integer types of MariaDB, MySQL, SQLite and PostgreSQL, less any length or modifiers.
+var integerTypes = []string{`int`, `integer`, `tinyint`, `smallint`, `mediumint`, `bigint`, `int2`, `int4`, `int8`,
+	`serial`, `smallserial`, `bigserial`}
+
+// isInteger reports whether dbType, such as `bigint(20) unsigned`, is one of the integerTypes.
+func isInteger(dbType string) bool {
+	words := strings.Fields(strings.ToLower(baseType(dbType)))
+	return len(words) > 0 && sliceContains(integerTypes, words[0])
+}
+
+// validatePayload checks a POST body, a row or an array of rows, or a PATCH body, whose rows may leave out required
+// columns. Strict requests also have keys that aren't columns refused.
+func (t Table) validatePayload(r *http.Request, body []byte, strict bool) error {
+	schema := t.jsonSchema(strict)
+	if schema == nil {
+		return nil
+	}
+	if r.Method == http.MethodPut && sliceContains(schema.Required, t.primaryKey()) {
+		schema = schema.optional(t.primaryKey()) // A PUT may name its row in the URL instead.
+	}
+
+	var fields []FieldError
+	if body[0] == '[' {
+		i := 0
+		_, _ = jsonparser.ArrayEach(body, func(row []byte, dataType jsonparser.ValueType, offset int, err error) {
+			fields = append(fields, schema.validate(row, dataType, `/`+strconv.Itoa(i), r.Method == http.MethodPatch)...)
+			i++
+		})
+	} else {
+		fields = schema.validate(body, jsonparser.Object, ``, r.Method == http.MethodPatch)
+	}
+
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// optional is a copy of the schema that doesn't require the named property.
+func (s *JSONSchema) optional(name string) *JSONSchema {
+	copied := *s
+	copied.Required = nil
+	for _, req := range s.Required {
+		if req != name {
+			copied.Required = append(copied.Required, req)
+		}
+	}
+
+	return &copied
+}
+
+// validate checks a single JSON value. partial skips the required check, for updates that change only some columns.
+func (s *JSONSchema) validate(val []byte, dataType jsonparser.ValueType, path string, partial bool) []FieldError {
+	fail := func(format string, args ...interface{}) []FieldError {
+		return []FieldError{{Path: path, Message: fmt.Sprintf(format, args...)}}
+	}
+
+	if len(s.Type) > 0 && !s.allowsType(val, dataType) {
+		return fail(`expected %s but got %s`, strings.Join(s.Type, ` or `), dataType)
+	}
+	if len(s.Enum) > 0 && !s.allowsValue(val, dataType) {
+		return fail(`value is not one of the allowed values`)
+	}
+
+	switch dataType {
+	case jsonparser.String:
+		str, err := jsonparser.ParseString(val)
+		if err != nil {
+			return fail(err.Error())
+		}
+		if s.MaxLength > 0 && utf8.RuneCountInString(str) > s.MaxLength {
+			return fail(`longer than %d characters`, s.MaxLength)
+		}
+	case jsonparser.Number:
+		num, err := jsonparser.ParseFloat(val)
+		if err != nil {
+			return fail(err.Error())
+		}
+		if s.Minimum != nil && num < *s.Minimum {
+			return fail(`less than %v`, *s.Minimum)
+		}
+		if s.Maximum != nil && num > *s.Maximum {
+			return fail(`greater than %v`, *s.Maximum)
+		}
+	case jsonparser.Object:
+		return s.validateObject(val, path, partial)
+	}
+
+	return nil
+}
+
+func (s *JSONSchema) validateObject(val []byte, path string, partial bool) []FieldError {
+	var fields []FieldError
+	seen := make(map[string]bool)
+	_ = jsonparser.ObjectEach(val, func(key []byte, value []byte, dataType jsonparser.ValueType, offset int) error {
+		name := string(key)
+		seen[name] = true
+		prop, ok := s.Properties[name]
+		switch {
+		case ok:
+			fields = append(fields, prop.validate(value, dataType, path+`/`+name, false)...)
+		case s.AdditionalProperties != nil && !*s.AdditionalProperties:
+			fields = append(fields, FieldError{Path: path + `/` + name, Message: `unknown column`})
+		}
+		return nil
+	})
+
+	if !partial {
+		for _, name := range s.Required {
+			if !seen[name] {
+				fields = append(fields, FieldError{Path: path + `/` + name, Message: `required`})
+			}
+		}
+	}
+
+	return fields
+}
+
+func (s *JSONSchema) allowsType(val []byte, dataType jsonparser.ValueType) bool {
+	for _, typ := range s.Type {
+		switch {
+		case typ == `string` && dataType == jsonparser.String,
+			typ == `number` && dataType == jsonparser.Number,
+			typ == `boolean` && dataType == jsonparser.Boolean,
+			typ == `null` && dataType == jsonparser.Null,
+			typ == `object` && dataType == jsonparser.Object,
+			typ == `array` && dataType == jsonparser.Array:
+			return true
+		case typ == `integer` && dataType == jsonparser.Number:
+			num, err := jsonparser.ParseFloat(val)
+			if err == nil && num == math.Trunc(num) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (s *JSONSchema) allowsValue(val []byte, dataType jsonparser.ValueType) bool {
+	for _, allowed := range s.Enum {
+		if str, ok := allowed.(string); ok {
+			if dataType != jsonparser.String {
+				continue
+			}
+			if parsed, err := jsonparser.ParseString(val); err == nil && parsed == str {
+				return true
+			}
+			continue
+		}
+		if encoded, err := json.Marshal(allowed); err == nil && string(encoded) == string(val) {
+			return true
+		}
+	}
+
+	return false
+}
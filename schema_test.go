@@ -0,0 +1,160 @@
+package bartlett
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// schemaDriver reports the kind of column metadata that schemas are generated from.
+type schemaDriver struct {
+	dummyDriver
+}
+
+func (d schemaDriver) GetColumns(context.Context, *sql.DB, Table) ([]Column, error) {
+	return []Column{
+		{Name: `id`, Type: `int(11)`, PrimaryKey: true, NotNull: true, HasDefault: true},
+		{Name: `name`, Type: `varchar(5)`, NotNull: true, Length: 5},
+		{Name: `age`, Type: `int(11)`, NotNull: true},
+		{Name: `grade`, Type: `enum('a','b')`, Enum: []string{`a`, `b`}},
+		{Name: `owner`, Type: `int(11)`, NotNull: true},
+	}, nil
+}
+
+func TestSchemaValidation(t *testing.T) {
+	b := Bartlett{
+		Driver: schemaDriver{},
+		Tables: []Table{{Name: `students`, Writable: true, UserID: `owner`}},
+		Users:  dummyUserProvider,
+	}
+	handler := b.Handler()
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, `/students`, strings.NewReader(
+		`[{"name":"todd"},{"name":"theodore","age":1.5,"nickname":"ted","owner":2}]`)))
+	if resp.Code != http.StatusUnprocessableEntity {
+		t.Errorf(`Expected "422" but got %d for status code`, resp.Code)
+	}
+	var out struct {
+		Fields []FieldError `json:"fields"`
+	}
+	err := json.Unmarshal(resp.Body.Bytes(), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := make([]string, len(out.Fields))
+	for i, field := range out.Fields {
+		paths[i] = field.Path
+	}
+	for _, path := range []string{`/0/age`, `/1/name`, `/1/age`} {
+		if !sliceContains(paths, path) {
+			t.Errorf(`Expected an error at %s but got %s`, path, resp.Body.String())
+		}
+	}
+	if len(paths) != 3 {
+		t.Errorf(`Expected 3 errors, and none for the unknown key of a lenient request, but got %s`, resp.Body.String())
+	}
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPatch, `/students?id=eq.1`, strings.NewReader(`{"grade":"c"}`)))
+	if resp.Code != http.StatusUnprocessableEntity || !strings.Contains(resp.Body.String(), `"path":"/grade"`) {
+		t.Errorf(`Expected "422" for /grade but got %d with body %s`, resp.Code, resp.Body.String())
+	}
+}
+
+func TestSchemaPartialUpdate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	b := Bartlett{
+		DB:     db,
+		Driver: schemaDriver{},
+		Tables: []Table{{Name: `students`, Writable: true}},
+		Users:  dummyUserProvider,
+	}
+	handler := b.Handler()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE students SET grade = \? WHERE id = \?`).
+		WithArgs([]uint8(`null`), `1`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPatch, `/students/1`, strings.NewReader(`{"grade":null}`)))
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	schema, ok := b.JSONSchema(`students`)
+	if !ok {
+		t.Fatal(`Expected a generated schema`)
+	}
+	out, _ := json.Marshal(schema.Properties[`age`])
+	if string(out) != `{"type":["integer"]}` {
+		t.Errorf(`Expected age to be an integer but got %s`, out)
+	}
+	if strings.Join(schema.Required, `,`) != `name,age,owner` {
+		t.Errorf(`Expected name, age and owner to be required but got %v`, schema.Required)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSchemaOverride(t *testing.T) {
+	minimum := 18.0
+	b := Bartlett{
+		Driver: schemaDriver{},
+		Tables: []Table{{
+			Name:     `students`,
+			Writable: true,
+			Schema: &JSONSchema{
+				Properties: map[string]*JSONSchema{`age`: {Type: []string{`integer`}, Minimum: &minimum}},
+			},
+		}},
+		Users: dummyUserProvider,
+	}
+	handler := b.Handler()
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, `/students`, strings.NewReader(`{"age":12}`)))
+	if resp.Code != http.StatusUnprocessableEntity || !strings.Contains(resp.Body.String(), `"path":"/age"`) {
+		t.Errorf(`Expected "422" for /age but got %d with body %s`, resp.Code, resp.Body.String())
+	}
+}
+
+func TestSchemaClosed(t *testing.T) {
+	tbl := Table{Name: `students`}
+	tbl.setColumns([]Column{{Name: `id`, Type: `int(11)`}})
+	req := httptest.NewRequest(http.MethodPost, `/students`, nil)
+
+	if err := tbl.validatePayload(req, []byte(`{"id":1,"nickname":"ted"}`), false); err != nil {
+		t.Errorf(`Expected unknown keys to be ignored but got %v`, err)
+	}
+	err := tbl.validatePayload(req, []byte(`{"id":1,"nickname":"ted"}`), true)
+	invalid, ok := err.(*ValidationError)
+	if !ok || len(invalid.Fields) != 1 || invalid.Fields[0].Path != `/nickname` {
+		t.Errorf(`Expected an unknown column at /nickname but got %v`, err)
+	}
+}
+
+func TestColumnSchemaIntegers(t *testing.T) {
+	for _, dbType := range []string{`int(11)`, `INTEGER`, `bigint(20) unsigned`, `int unsigned`, `smallint`, `serial`} {
+		if schema := columnSchema(Column{Type: dbType, NotNull: true}); len(schema.Type) != 1 || schema.Type[0] != `integer` {
+			t.Errorf(`Expected %s to be an integer but got %v`, dbType, schema.Type)
+		}
+	}
+	for _, dbType := range []string{`point`, `multipoint`, `interval`, `linestring`} {
+		if schema := columnSchema(Column{Type: dbType}); schema.Type != nil {
+			t.Errorf(`Expected %s to accept anything but got %v`, dbType, schema.Type)
+		}
+	}
+}
@@ -9,7 +9,7 @@ import (
 )
 
 func (b Bartlett) buildSelect(t Table, r *http.Request) (sqrl.SelectBuilder, error) {
-	query := selectColumns(t, r).From(t.Name)
+	query := selectColumns(t, r).From(t.sqlName())
 	query = selectWhere(query, t, r)
 	query = selectOrder(query, t, r)
 	query = selectLimit(query, r)
@@ -19,7 +19,10 @@ func (b Bartlett) buildSelect(t Table, r *http.Request) (sqrl.SelectBuilder, err
 		if err != nil {
 			return query, err
 		}
-		query = query.Where(sqrl.Eq{t.UserID: userID})
+		query = query.Where(sqrl.Eq{t.quote(t.UserID): userID})
+	}
+	if filter, ok := t.deletedFilter(r); ok {
+		query = query.Where(filter)
 	}
 
 	return query, nil
@@ -55,7 +58,8 @@ func parseOrder(t Table, r *http.Request) []orderSpec {
 			} else {
 				order.Column = col
 			}
-			if sliceContains(t.columns, order.Column) {
+			if t.readable(order.Column) {
+				order.Column = t.columnSQL(order.Column)
 				out = append(out, order) // Omit anything not in the table spec
 			}
 		}
@@ -68,6 +72,9 @@ func selectColumns(t Table, r *http.Request) sqrl.SelectBuilder {
 	var query sqrl.SelectBuilder
 	columns := parseColumns(t, r)
 	if len(columns) > 0 {
+		for i, col := range columns {
+			columns[i] = t.selectSQL(col)
+		}
 		query = sqrl.Select(columns[0])
 		query = query.Columns(columns[1:]...)
 	} else {
@@ -114,32 +121,12 @@ func selectLimit(query sqrl.SelectBuilder, r *http.Request) sqrl.SelectBuilder {
 }
 
 func selectWhere(query sqrl.SelectBuilder, t Table, r *http.Request) sqrl.SelectBuilder {
-	i := 0
-	columns := make([]string, len(r.URL.Query()))
-	for k := range r.URL.Query() {
-		columns[i] = k
-		i++
+	if id, ok := itemID(r); ok {
+		query = query.Where(sqrl.Eq{t.quote(t.primaryKey()): id})
 	}
-	columns = t.validReadColumns(columns)
-
-	for column, values := range r.URL.Query() {
-		if sliceContains(columns, column) {
-			for _, rawCond := range values {
-				parsedCond, val := parseSimpleWhereCond(rawCond)
-				var cond string
-				if parsedCond == `in` || parsedCond == `not.in` {
-					if cond == `not.in` {
-						query = query.Where(sqrl.NotEq{column: whereIn(val)})
-					} else {
-						query = query.Where(sqrl.Eq{column: whereIn(val)})
-					}
-				} else {
-					cond = urlToWhereCond(column, parsedCond)
-					sqlCond, val := rectifyArg(cond, val)
-					query = query.Where(sqlCond, val)
-				}
-			}
-		}
+	conds, _ := t.filters(r) // handleRoute has already refused filters that don't compile.
+	for _, cond := range conds {
+		query = query.Where(cond)
 	}
 
 	return query
@@ -1,7 +1,9 @@
 package bartlett
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	sqrl "github.com/Masterminds/squirrel"
 	"net/http"
 	"strings"
@@ -14,15 +16,32 @@ func dummyUserProvider(_ *http.Request) (interface{}, error) {
 
 type dummyDriver struct{}
 
-func (d dummyDriver) MarshalResults(_ *sql.Rows, _ http.ResponseWriter) error {
+func (d dummyDriver) MarshalResults(*sql.Rows, http.ResponseWriter, Encoding) error {
 	return nil
 }
 
-func (d dummyDriver) GetColumns(*sql.DB, Table) ([]string, error) {
-	return []string{`id`, `name`, `a`, `b`}, nil
+func (d dummyDriver) GetColumns(context.Context, *sql.DB, Table) ([]Column, error) {
+	return []Column{
+		{Name: `id`, Type: `INTEGER`, PrimaryKey: true},
+		{Name: `name`, Type: `TEXT`},
+		{Name: `a`, Type: `TEXT`},
+		{Name: `b`, Type: `INTEGER`},
+	}, nil
 }
 
-func (d dummyDriver) ProbeTables(db *sql.DB) []Table {
+func (d dummyDriver) FilterSQL(string, string) (string, bool) {
+	return ``, false
+}
+
+func (d dummyDriver) JSONPath(column, path string, text bool) string {
+	return fmt.Sprintf(`json_path(%s, '%s', %t)`, column, path, text)
+}
+
+func (d dummyDriver) QuoteIdent(name string) string {
+	return name
+}
+
+func (d dummyDriver) ProbeTables(context.Context, *sql.DB, string) ([]Table, error) {
 	return []Table{
 		{
 			Name: `students`,
@@ -30,7 +49,7 @@ func (d dummyDriver) ProbeTables(db *sql.DB) []Table {
 		{
 			Name: `teachers`,
 		},
-	}
+	}, nil
 }
 
 func TestSelect(t *testing.T) {
@@ -43,7 +62,7 @@ func TestSelect(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	b := Bartlett{&sql.DB{}, dummyDriver{}, []Table{table}, dummyUserProvider}
+	b := Bartlett{DB: &sql.DB{}, Driver: dummyDriver{}, Tables: []Table{table}, Users: dummyUserProvider}
 
 	builder, err := b.buildSelect(table, req)
 	if err != nil {
@@ -0,0 +1,110 @@
+package bartlett
+
+import (
+	"errors"
+	sqrl "github.com/Masterminds/squirrel"
+	"net/http"
+)
+
+// A SoftDeleteSpec names a column that marks rows as deleted, so that DELETE never removes anything.
+// DELETE sets the column to CURRENT_TIMESTAMP, or to true when Flag is set, and GET and PATCH skip marked rows.
+// A PATCH with the `restore` parameter clears the mark on the rows it matches.
+// Allow decides who may restore rows or see them with `include_deleted`. If it is nil, nobody may.
+// Only DELETE and `restore` write the column; a value sent for it in a POST, PATCH or PUT body is ignored.
+type SoftDeleteSpec struct {
+	Name  string
+	Flag  bool
+	Allow func(r *http.Request) bool
+}
+
+func (s SoftDeleteSpec) mark() interface{} {
+	if s.Flag {
+		return true
+	}
+	return sqrl.Expr(`CURRENT_TIMESTAMP`)
+}
+
+func (s SoftDeleteSpec) unmark() interface{} {
+	if s.Flag {
+		return false
+	}
+	return nil
+}
+
+// live matches the rows that aren't marked, given the column's quoted name.
+func (s SoftDeleteSpec) live(column string) sqrl.Sqlizer {
+	if s.Flag {
+		return sqrl.Or{sqrl.Eq{column: nil}, sqrl.Eq{column: false}}
+	}
+	return sqrl.Eq{column: nil}
+}
+
+// deleted matches the marked rows, given the column's quoted name.
+func (s SoftDeleteSpec) deleted(column string) sqrl.Sqlizer {
+	if s.Flag {
+		return sqrl.Eq{column: true}
+	}
+	return sqrl.NotEq{column: nil}
+}
+
+// restoring reports whether r is a PATCH that undoes a soft delete.
+func restoring(r *http.Request) bool {
+	_, ok := r.URL.Query()[`restore`]
+	return ok && r.Method == http.MethodPatch
+}
+
+// includeDeleted reports whether r is a GET asking for soft-deleted rows as well as live ones.
+func includeDeleted(r *http.Request) bool {
+	_, ok := r.URL.Query()[`include_deleted`]
+	return ok && r.Method == http.MethodGet
+}
+
+// checkSoftDelete refuses `include_deleted` and `restore` to requests that SoftDeleteSpec.Allow turns down.
+func (t Table) checkSoftDelete(r *http.Request) error {
+	if t.SoftDelete.Name == `` || !(restoring(r) || includeDeleted(r)) {
+		return nil
+	}
+	if t.SoftDelete.Allow == nil || !t.SoftDelete.Allow(r) {
+		return errors.New(`not allowed to see deleted rows`)
+	}
+
+	return nil
+}
+
+// deletedFilter limits a query to live rows, or to soft-deleted rows when restoring them.
+// ok is false when the table has no soft-delete column or the request asked to include deleted rows.
+func (t Table) deletedFilter(r *http.Request) (filter sqrl.Sqlizer, ok bool) {
+	switch {
+	case t.SoftDelete.Name == ``:
+		return nil, false
+	case restoring(r):
+		return t.SoftDelete.deleted(t.quote(t.SoftDelete.Name)), true
+	case includeDeleted(r):
+		return nil, false
+	}
+
+	return t.SoftDelete.live(t.quote(t.SoftDelete.Name)), true
+}
+
+// buildSoftDelete turns a DELETE into an UPDATE that marks the matching live rows as deleted.
+func (b Bartlett) buildSoftDelete(t Table, r *http.Request) (sqrl.UpdateBuilder, error) {
+	query, err := updateWhere(sqrl.Update(t.sqlName()).Set(t.quote(t.SoftDelete.Name), t.SoftDelete.mark()), t, r)
+	if err != nil {
+		return query, errors.New(`DELETE operations must have at least one WHERE clause`)
+	}
+	query = updateOrder(query, t, r)
+	query = updateLimit(query, r)
+
+	if t.UserID != `` {
+		userID, err := b.Users(r)
+		if err != nil {
+			return query, err
+		}
+		query = query.Where(sqrl.Eq{t.quote(t.UserID): userID})
+	}
+	if filter, ok := t.deletedFilter(r); ok {
+		query = query.Where(filter)
+	}
+
+	return query, nil
+}
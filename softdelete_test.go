@@ -0,0 +1,130 @@
+package bartlett
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSoftDelete(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	admin := func(r *http.Request) bool {
+		return r.Header.Get(`X-Admin`) != ``
+	}
+	b := Bartlett{
+		DB:     db,
+		Driver: dummyDriver{},
+		Tables: []Table{
+			{Name: `students`, Writable: true, SoftDelete: SoftDeleteSpec{Name: `deleted_at`, Allow: admin}},
+		},
+		Users: dummyUserProvider,
+	}
+	handler := b.Handler()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE students SET deleted_at = CURRENT_TIMESTAMP WHERE id = \? AND deleted_at IS NULL`).
+		WithArgs(`4`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodDelete, `/students/4`, nil))
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	mock.ExpectQuery(`SELECT \* FROM students WHERE deleted_at IS NULL`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`}))
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, `/students`, nil))
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, `/students?include_deleted`, nil))
+	if resp.Code != http.StatusForbidden {
+		t.Errorf(`Expected "403" but got %d for status code`, resp.Code)
+	}
+
+	mock.ExpectQuery(`SELECT \* FROM students$`).
+		WillReturnRows(sqlmock.NewRows([]string{`id`}))
+	req := httptest.NewRequest(http.MethodGet, `/students?include_deleted`, nil)
+	req.Header.Set(`X-Admin`, `1`)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE students SET name = \? WHERE id = \? AND deleted_at IS NULL`).
+		WithArgs([]uint8(`ned`), `4`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPatch, `/students/4`, strings.NewReader(`{"name":"ned","deleted_at":null}`)))
+	if resp.Code != http.StatusNotFound {
+		t.Errorf(`Expected "404" but got %d for status code`, resp.Code)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE students SET deleted_at = \? WHERE id = \? AND deleted_at IS NOT NULL`).
+		WithArgs(nil, `4`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	req = httptest.NewRequest(http.MethodPatch, `/students/4?restore`, nil)
+	req.Header.Set(`X-Admin`, `1`)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSoftDeleteFlag(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	b := Bartlett{
+		DB:     db,
+		Driver: dummyDriver{},
+		Tables: []Table{
+			{Name: `students`, Writable: true, SoftDelete: SoftDeleteSpec{Name: `is_deleted`, Flag: true}},
+		},
+		Users: dummyUserProvider,
+	}
+	handler := b.Handler()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE students SET is_deleted = \? WHERE name = \? AND \(is_deleted IS NULL OR is_deleted = \?\)`).
+		WithArgs(true, `todd`, false).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodDelete, `/students?name=eq.todd`, nil))
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPatch, `/students?name=eq.todd&restore`, nil))
+	if resp.Code != http.StatusForbidden {
+		t.Errorf(`Expected "403" without an Allow func but got %d for status code`, resp.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,60 @@
+//go:build sqlite_json
+// +build sqlite_json
+
+package sqlite3
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/royallthefourth/bartlett"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// JSON paths need SQLite's JSON1 functions, which go-sqlite3 only builds with `-tags sqlite_json`.
+func TestJSONPaths(t *testing.T) {
+	db, err := sql.Open(`sqlite3`, "file:json.db?cache=shared&mode=memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE TABLE orders(order_id INTEGER PRIMARY KEY AUTOINCREMENT, data JSON);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO orders(data) VALUES
+		('{"status":"active","total":30,"address":{"city":"Oslo"},"tags":["a","b"]}'),
+		('{"status":"closed","total":10,"address":{"city":"Rome"},"tags":["c"]}');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := bartlett.Bartlett{DB: db, Driver: &SQLite3{}, Tables: []bartlett.Table{{Name: `orders`}}, Users: dummyUserProvider}
+	handler := b.Handler()
+
+	for query, expected := range map[string]string{
+		`select=order_id,data->address->city&data->>status=eq.active`: `[{"city":"Oslo","order_id":1}]`,
+		`select=data->address&order=data->>total.asc`:                 `[{"address":{"city":"Rome"}},{"address":{"city":"Oslo"}}]`,
+		`select=data->tags->0,data->>status&data->>status=neq.closed`: `[{"status":"active","tags_0":"a"}]`,
+		`select=data&order_id=eq.2`:                                   `[{"data":{"status":"closed","total":10,"address":{"city":"Rome"},"tags":["c"]}}]`,
+	} {
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, `/orders?`+query, nil))
+		if resp.Code != http.StatusOK {
+			t.Errorf(`Expected "200" for %s but got %d with body %s`, query, resp.Code, resp.Body.String())
+			continue
+		}
+		var got, want interface{}
+		if err := json.Unmarshal(resp.Body.Bytes(), &got); err != nil {
+			t.Errorf(`Expected JSON for %s but got %s`, query, resp.Body.String())
+			continue
+		}
+		_ = json.Unmarshal([]byte(expected), &want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf(`Expected %s for %s but got %s`, expected, query, resp.Body.String())
+		}
+	}
+}
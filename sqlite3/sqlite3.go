@@ -2,59 +2,207 @@
 package sqlite3
 
 import (
+	"context"
 	"database/sql"
 	coredriver "database/sql/driver"
 	"encoding/json"
 	"fmt"
-	sqrl "github.com/Masterminds/squirrel"
 	"github.com/royallthefourth/bartlett"
-	"log"
 	"net/http"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
 // SQLite3 provides logic specific to SQLite3 databases.
-type SQLite3 struct {
-	tables map[string][]column
-}
+// It holds no state, so a single value may be shared between Bartlett instances and concurrent calls to Refresh.
+type SQLite3 struct{}
 
-type column struct {
-	dataType reflect.Type
-	name     string
-}
+// GetColumns reads the table's columns from `PRAGMA table_xinfo`, which works for views and attached databases too.
+// Hidden columns of virtual tables are left out. Generated columns are reported, but API users can't write them.
+func (driver *SQLite3) GetColumns(ctx context.Context, db *sql.DB, t bartlett.Table) ([]bartlett.Column, error) {
+	schema, name := splitName(t.Name)
+	rowidAlias, err := hasRowidAlias(ctx, db, schema, name)
+	if err != nil {
+		return []bartlett.Column{}, err
+	}
 
-// GetColumns queries `sqlite_master` and returns a list of valid column names.
-func (driver *SQLite3) GetColumns(db *sql.DB, t bartlett.Table) ([]string, error) {
-	if driver.tables == nil {
-		driver.tables = make(map[string][]column)
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`PRAGMA %s.table_xinfo(%s)`, quoteIdent(schema), quoteIdent(name)))
+	if err != nil {
+		return []bartlett.Column{}, err
 	}
+	defer rows.Close()
+
 	var (
-		createQuery string
-		out         []string
+		out  []bartlett.Column
+		keys int
 	)
-	rows, err := sqrl.Select(`sql`).From(`sqlite_master`).Where(`name = ?`, t.Name).RunWith(db).Query()
+	for rows.Next() {
+		var (
+			cid, pk, hidden int
+			colName, dbType string
+			notNull         bool
+			dflt            sql.NullString
+		)
+		err = rows.Scan(&cid, &colName, &dbType, &notNull, &dflt, &pk, &hidden)
+		if err != nil {
+			return []bartlett.Column{}, err
+		}
+		if hidden == 1 {
+			continue
+		}
+		col := bartlett.Column{
+			Name:       colName,
+			Type:       dbType,
+			Affinity:   affinity(dbType),
+			PrimaryKey: pk > 0,
+			NotNull:    notNull,
+			HasDefault: dflt.Valid || hidden > 1,
+			Default:    dflt.String,
+			Generated:  hidden > 1, // 2 is VIRTUAL and 3 is STORED.
+		}
+		if match := charLength.FindStringSubmatch(dbType); match != nil {
+			col.Length, _ = strconv.Atoi(match[1])
+		}
+		if col.PrimaryKey {
+			keys++
+		}
+		out = append(out, col)
+	}
+	if err = rows.Err(); err != nil {
+		return []bartlett.Column{}, err
+	}
+	if len(out) == 0 {
+		return []bartlett.Column{}, fmt.Errorf(`no such table: %s`, t.Name)
+	}
+
+	rows.Close() // SQLite won't run the PRAGMA below while this query is still open on a single connection.
+
+	fkeys, err := foreignKeys(ctx, db, schema, name)
 	if err != nil {
-		return []string{}, err
+		return []bartlett.Column{}, err
+	}
+	for i, col := range out {
+		out[i].References = fkeys[col.Name]
+		if col.PrimaryKey && keys == 1 && rowidAlias && strings.EqualFold(col.Type, `INTEGER`) {
+			out[i].HasDefault = true // An INTEGER PRIMARY KEY is the rowid, which SQLite assigns.
+		}
 	}
 
-	rows.Next() // We should only expect a single row here.
-	err = rows.Scan(&createQuery)
+	return out, nil
+}
+
+// hasRowidAlias reports whether a table's INTEGER PRIMARY KEY, if it has one, is an alias for the rowid.
+// It isn't in a WITHOUT ROWID table, or one declared with PRIMARY KEY DESC: both give the key an index of its own.
+func hasRowidAlias(ctx context.Context, db *sql.DB, schema, table string) (bool, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`PRAGMA %s.index_list(%s)`, quoteIdent(schema), quoteIdent(table)))
 	if err != nil {
-		return []string{}, err
+		return false, err
 	}
+	defer rows.Close()
 
-	driver.tables[t.Name] = parseCreateTable(createQuery)
-	for _, col := range driver.tables[t.Name] {
-		out = append(out, col.name)
+	for rows.Next() {
+		var (
+			seq, unique, partial int
+			index, origin        string
+		)
+		if err = rows.Scan(&seq, &index, &unique, &origin, &partial); err != nil {
+			return false, err
+		}
+		if origin == `pk` {
+			return false, nil
+		}
 	}
 
-	return out, err
+	return true, rows.Err()
+}
+
+// QuoteIdent quotes a name in double quotes, as standard SQL does.
+func (driver SQLite3) QuoteIdent(name string) string {
+	return quoteIdent(name)
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// foreignKeys maps each column of a table that has a foreign key to the column it references.
+// SQLite only allows foreign keys within one database, so the referenced table shares the table's schema.
+// Composite foreign keys are left out, as a single column can't follow them.
+func foreignKeys(ctx context.Context, db *sql.DB, schema, table string) (map[string]*bartlett.ForeignKey, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`PRAGMA %s.foreign_key_list(%s)`, quoteIdent(schema), quoteIdent(table)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make(map[string]*bartlett.ForeignKey)
+	constraints := make(map[int][]string)
+	for rows.Next() {
+		var (
+			id, seq                         int
+			parent, from                    string
+			to                              sql.NullString
+			onUpdate, onDelete, matchClause string
+		)
+		if err := rows.Scan(&id, &seq, &parent, &from, &to, &onUpdate, &onDelete, &matchClause); err != nil {
+			return nil, err
+		}
+		if schema != `main` {
+			parent = schema + `.` + parent
+		}
+		keys[from] = &bartlett.ForeignKey{Table: parent, Column: to.String}
+		constraints[id] = append(constraints[id], from)
+	}
+	for _, names := range constraints {
+		if len(names) > 1 {
+			for _, name := range names {
+				delete(keys, name)
+			}
+		}
+	}
+
+	return keys, rows.Err()
+}
+
+// FilterSQL uses SQLite's IS and IS NOT, which compare NULLs as values. SQLite's LIKE already ignores ASCII case,
+// and it has no UNKNOWN, which is NULL.
+func (driver SQLite3) FilterSQL(op, column string) (string, bool) {
+	switch op {
+	case `nseq`:
+		return fmt.Sprintf(`%s IS ?`, column), true
+	case `isdistinct`:
+		return fmt.Sprintf(`%s IS NOT ?`, column), true
+	case `ilike`:
+		return fmt.Sprintf(`%s LIKE ?`, column), true
+	case `is.unknown`:
+		return fmt.Sprintf(`%s IS NULL`, column), true
+	default:
+		return ``, false
+	}
+}
+
+// JSONPath uses json_extract, which returns strings and numbers as SQL values but objects and arrays as JSON text.
+// json_quote turns the former into JSON too.
+func (driver SQLite3) JSONPath(column, path string, text bool) string {
+	if text {
+		return fmt.Sprintf(`json_extract(%s, '%s')`, column, path)
+	}
+	return fmt.Sprintf(`json_quote(json_extract(%s, '%s'))`, column, path)
+}
+
+// splitName separates an attached database name from a table name, defaulting to `main`.
+func splitName(name string) (schema, table string) {
+	if i := strings.Index(name, `.`); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+
+	return `main`, name
 }
 
 // MarshalResults converts results from SQLite3 types to Go types, then outputs JSON to the ResponseWriter.
-func (driver SQLite3) MarshalResults(rows *sql.Rows, w http.ResponseWriter) error {
+func (driver SQLite3) MarshalResults(rows *sql.Rows, w http.ResponseWriter, enc bartlett.Encoding) error {
 	columns, err := rows.Columns()
 	if err != nil {
 		return fmt.Errorf(`column error: %v`, err)
@@ -66,6 +214,7 @@ func (driver SQLite3) MarshalResults(rows *sql.Rows, w http.ResponseWriter) erro
 	}
 
 	types := make([]reflect.Type, len(columnTypes))
+	encoders := make([]bartlett.Encoder, len(columnTypes))
 	for i, columnType := range columnTypes {
 		scanType := columnType.ScanType()
 		if scanType != nil {
@@ -73,6 +222,7 @@ func (driver SQLite3) MarshalResults(rows *sql.Rows, w http.ResponseWriter) erro
 		} else {
 			types[i] = dbTypeToGoType(columnType.DatabaseTypeName())
 		}
+		encoders[i] = enc.Encoder(columns[i], columnType.DatabaseTypeName())
 	}
 
 	values := make([]interface{}, len(columnTypes))
@@ -100,10 +250,16 @@ func (driver SQLite3) MarshalResults(rows *sql.Rows, w http.ResponseWriter) erro
 			return fmt.Errorf(`failed to scan values: %v`, err)
 		}
 		for i, v := range values {
-			data[columns[i]], err = v.(coredriver.Valuer).Value()
+			data[columns[i]], err = scanned(v)
 			if err != nil {
 				return fmt.Errorf(`failed to get value: %s`, err)
 			}
+			if encoders[i] != nil {
+				data[columns[i]], err = encoders[i](data[columns[i]])
+				if err != nil {
+					return fmt.Errorf(`failed to encode %s: %v`, columns[i], err)
+				}
+			}
 		}
 
 		jsonRow, err := json.Marshal(data)
@@ -125,10 +281,36 @@ func (driver SQLite3) MarshalResults(rows *sql.Rows, w http.ResponseWriter) erro
 	return err
 }
 
-func (driver *SQLite3) ProbeTables(db *sql.DB) []bartlett.Table {
-	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type='table'`)
+// scanned reads a value back from its scan destination. Columns without a Valuer scan type, such as JSON columns and
+// expressions, which have no declared type, scan into an interface{}.
+func scanned(v interface{}) (interface{}, error) {
+	if valuer, ok := v.(coredriver.Valuer); ok {
+		return valuer.Value()
+	}
+	val := reflect.ValueOf(v)
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return nil, nil
+		}
+		val = val.Elem()
+	}
+	if raw, ok := val.Interface().(sql.RawBytes); ok {
+		return append([]byte(nil), raw...), nil // RawBytes is only valid until the next Scan.
+	}
+
+	return val.Interface(), nil
+}
+
+// ProbeTables lists the tables and views in the attached database schema, or in `main` if schema is blank.
+// Views only accept writes if they have INSTEAD OF triggers. SQLite's own `sqlite_` tables, such as `sqlite_sequence` and `sqlite_stat1`, are skipped.
+func (driver *SQLite3) ProbeTables(ctx context.Context, db *sql.DB, schema string) ([]bartlett.Table, error) {
+	master := `sqlite_master`
+	if schema != `` {
+		master = quoteIdent(schema) + `.sqlite_master`
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT name FROM %s WHERE type IN ('table', 'view') AND name NOT LIKE 'sqlite\_%%' ESCAPE '\'`, master))
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -137,44 +319,55 @@ func (driver *SQLite3) ProbeTables(db *sql.DB) []bartlett.Table {
 	for rows.Next() {
 		var name string
 		if err := rows.Scan(&name); err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 
+		if schema != `` {
+			name = fmt.Sprintf(`%s.%s`, schema, name)
+		}
 		tables = append(tables, bartlett.Table{Name: name})
 	}
 
-	return tables
+	return tables, rows.Err()
+}
+
+// affinity is the type affinity SQLite gives a declared column type, following https://sqlite.org/datatype3.html#determination_of_column_affinity
+func affinity(dbType string) string {
+	t := strings.ToUpper(dbType)
+	switch {
+	case strings.Contains(t, `INT`):
+		return `INTEGER`
+	case strings.Contains(t, `CHAR`) || strings.Contains(t, `CLOB`) || strings.Contains(t, `TEXT`):
+		return `TEXT`
+	case strings.Contains(t, `BLOB`) || strings.TrimSpace(t) == ``:
+		return `BLOB`
+	case strings.Contains(t, `REAL`) || strings.Contains(t, `FLOA`) || strings.Contains(t, `DOUB`):
+		return `REAL`
+	default:
+		return `NUMERIC`
+	}
 }
 
 func dbTypeToGoType(dbType string) reflect.Type {
-	t := strings.TrimSpace(strings.ToLower(dbType))
-	if strings.Contains(t, `int`) {
+	t := strings.ToLower(dbType)
+	switch affinity(dbType) {
+	case `INTEGER`:
 		if strings.Contains(t, `unsigned`) {
 			return reflect.TypeOf(uint(0))
 		}
 		return reflect.TypeOf(int(0))
-	} else if strings.Contains(t, `char`) {
+	case `TEXT`:
 		return reflect.TypeOf(``)
-	} else if strings.Contains(t, `real`) ||
-		strings.Contains(t, `double`) ||
-		strings.Contains(t, `float`) {
+	case `REAL`:
 		return reflect.TypeOf(float64(0))
+	case `NUMERIC`:
+		if strings.Contains(t, `bool`) {
+			return reflect.TypeOf(false)
+		}
+		return reflect.TypeOf(float64(0))
+	default:
+		return reflect.TypeOf([]byte{})
 	}
-	return reflect.TypeOf([]byte{}) // Guess it's a blob
 }
 
-func parseCreateTable(sql string) (columns []column) {
-	colSpec := regexp.MustCompile(`.*CREATE\s+TABLE\s+(\S+)\s*\((.*)\).*`)
-	firstWord := regexp.MustCompile(`\s.*`)
-	specs := strings.Split(colSpec.FindStringSubmatch(sql)[2], `,`)
-	for _, spec := range specs {
-		colName := firstWord.ReplaceAllString(strings.TrimSpace(spec), ``)
-		rawType := firstWord.FindString(spec)
-		columns = append(columns, column{
-			dataType: dbTypeToGoType(rawType),
-			name:     colName,
-		})
-	}
-
-	return columns
-}
+var charLength = regexp.MustCompile(`^(?i)(?:var)?char\((\d+)\)`)
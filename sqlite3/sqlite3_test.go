@@ -1,14 +1,17 @@
 package sqlite3
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/royallthefourth/bartlett"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestSQLite3(t *testing.T) {
@@ -49,7 +52,10 @@ func TestSQLite3(t *testing.T) {
 		},
 	}
 
-	probedTables := (&SQLite3{}).ProbeTables(db)
+	probedTables, err := (&SQLite3{}).ProbeTables(context.Background(), db, ``)
+	if err != nil {
+		t.Fatal(err)
+	}
 	foundTables := make(map[string]bool)
 	for _, table := range probedTables {
 		foundTables[table.Name] = true
@@ -63,11 +69,52 @@ func TestSQLite3(t *testing.T) {
 		t.Error(`Table "teachers" not found`)
 	}
 
-	b := bartlett.Bartlett{db, &SQLite3{}, tables, dummyUserProvider}
+	if _, found := foundTables[`sqlite_sequence`]; found {
+		t.Error(`Internal table "sqlite_sequence" should not be probed`)
+	}
+
+	columns, err := (&SQLite3{}).GetColumns(context.Background(), db, bartlett.Table{Name: `main.students`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if columns[0].Name != `student_id` || !columns[0].PrimaryKey || columns[1].PrimaryKey {
+		t.Errorf(`Expected student_id to be the only primary key but got %+v`, columns)
+	}
+	if !columns[0].HasDefault || !columns[1].NotNull || columns[2].NotNull {
+		t.Errorf(`Expected only age to be required but got %+v`, columns)
+	}
+
+	b := bartlett.Bartlett{DB: db, Driver: &SQLite3{}, Tables: tables, Users: dummyUserProvider}
 
 	testSimpleGetAll(t, b)
 	testUserGetAll(t, b)
 	testGetColumn(t, b)
+	testFilters(t, b)
+}
+
+func testFilters(t *testing.T, b bartlett.Bartlett) {
+	handler := b.Handler()
+	for query, expected := range map[string]int{
+		`name=ilike.MR*`:             1,
+		`name=notnull`:               2,
+		`name=is.null`:               0,
+		`name=nseq.Ms. Key`:          1,
+		`name=isdistinct.null`:       2,
+		`teacher_id=between.2,5`:     1,
+		`teacher_id=not.between.1,1`: 1,
+	} {
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, `/teachers?`+strings.Replace(query, ` `, `%20`, -1), nil))
+		teachers := make([]teacher, 0)
+		err := json.Unmarshal(resp.Body.Bytes(), &teachers)
+		if err != nil {
+			t.Errorf(`Expected JSON for %s but got %s`, query, resp.Body.String())
+			continue
+		}
+		if len(teachers) != expected {
+			t.Errorf(`Expected %d teachers for %s but got %d`, expected, query, len(teachers))
+		}
+	}
 }
 
 func dummyUserProvider(_ *http.Request) (interface{}, error) {
@@ -180,9 +227,400 @@ func testUserGetAll(t *testing.T, b bartlett.Bartlett) {
 	}
 }
 
-func TestParseCreateTable(t *testing.T) {
-	columns := parseCreateTable(`CREATE TABLE students(age int NOT NULL, grade INT)`)
-	if columns[0].name != `age` || columns[1].name != `grade` {
-		t.Errorf(`Expected "age" and "grade" but got %+v`, columns)
+func TestGetColumns(t *testing.T) {
+	db, err := sql.Open(`sqlite3`, "file:columns.db?cache=shared&mode=memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, stmt := range []string{
+		`CREATE TABLE "order items"(
+			-- A comment, with a comma
+			"item id" INTEGER PRIMARY KEY,
+			price DECIMAL(10,2) NOT NULL DEFAULT 0,
+			name VARCHAR(40),
+			total REAL GENERATED ALWAYS AS (price * 2) VIRTUAL,
+			CONSTRAINT positive CHECK (price >= 0)
+		)`,
+		`CREATE TABLE tags(tag TEXT PRIMARY KEY, hits INTEGER) WITHOUT ROWID`,
+		`CREATE VIEW names AS SELECT name, price FROM "order items"`,
+		`ATTACH DATABASE 'file:other.db?cache=shared&mode=memory' AS other`,
+		`CREATE TABLE other.notes(note_id INTEGER PRIMARY KEY, body TEXT NOT NULL)`,
+	} {
+		if _, err = db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	driver := &SQLite3{}
+	columns, err := driver.GetColumns(context.Background(), db, bartlett.Table{Name: `order items`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []bartlett.Column{
+		{Name: `item id`, Type: `INTEGER`, Affinity: `INTEGER`, PrimaryKey: true, HasDefault: true},
+		{Name: `price`, Type: `DECIMAL(10,2)`, Affinity: `NUMERIC`, NotNull: true, HasDefault: true, Default: `0`},
+		{Name: `name`, Type: `VARCHAR(40)`, Affinity: `TEXT`, Length: 40},
+		{Name: `total`, Type: `REAL`, Affinity: `REAL`, HasDefault: true, Generated: true},
+	}
+	if !reflect.DeepEqual(columns, expected) {
+		t.Errorf(`Expected %+v but got %+v`, expected, columns)
+	}
+
+	columns, err = driver.GetColumns(context.Background(), db, bartlett.Table{Name: `tags`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(columns) != 2 || !columns[0].PrimaryKey || columns[0].HasDefault {
+		t.Errorf(`Expected a primary key without a default for a WITHOUT ROWID table but got %+v`, columns)
+	}
+
+	columns, err = driver.GetColumns(context.Background(), db, bartlett.Table{Name: `names`})
+	if err != nil || len(columns) != 2 || columns[1].Type != `DECIMAL(10,2)` {
+		t.Errorf(`Expected the view's two columns but got %+v, %v`, columns, err)
+	}
+
+	columns, err = driver.GetColumns(context.Background(), db, bartlett.Table{Name: `other.notes`})
+	if err != nil || len(columns) != 2 || !columns[0].HasDefault || !columns[1].NotNull {
+		t.Errorf(`Expected the attached table's columns but got %+v, %v`, columns, err)
+	}
+
+	if _, err = driver.GetColumns(context.Background(), db, bartlett.Table{Name: `missing`}); err == nil {
+		t.Errorf(`Expected an error for a missing table`)
+	}
+
+	tables, err := driver.ProbeTables(context.Background(), db, ``)
+	if err != nil || len(tables) != 3 {
+		t.Errorf(`Expected two tables and a view but got %+v, %v`, tables, err)
+	}
+
+	if affinity(`DECIMAL(10,2)`) != `NUMERIC` || affinity(`VARCHAR(40)`) != `TEXT` || affinity(``) != `BLOB` ||
+		affinity(`DOUBLE PRECISION`) != `REAL` || affinity(`BIGINT`) != `INTEGER` {
+		t.Errorf(`Expected SQLite's type affinities`)
+	}
+}
+
+func TestNestedInsert(t *testing.T) {
+	db, err := sql.Open(`sqlite3`, "file:nested.db?cache=shared&mode=memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE TABLE classes(class_id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE enrollments(enrollment_id INTEGER PRIMARY KEY AUTOINCREMENT, class_id INTEGER NOT NULL REFERENCES classes(class_id), student TEXT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	columns, err := (&SQLite3{}).GetColumns(context.Background(), db, bartlett.Table{Name: `enrollments`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := columns[1].References; key == nil || key.Table != `classes` || key.Column != `class_id` {
+		t.Errorf(`Expected class_id to reference classes.class_id but got %+v`, key)
+	}
+
+	b := bartlett.Bartlett{
+		DB:     db,
+		Driver: &SQLite3{},
+		Tables: []bartlett.Table{
+			{Name: `classes`, Writable: true},
+			{Name: `enrollments`, Writable: true},
+		},
+		Users: dummyUserProvider,
+	}
+	handler := b.Handler()
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, `/classes`, strings.NewReader(
+		`{"name":"Art","enrollments":[{"student":"ann"},{"student":"bo"}]}`)))
+	if resp.Code != http.StatusOK {
+		t.Fatalf(`Expected "200" but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+
+	var count int
+	err = db.QueryRow(`SELECT COUNT(*) FROM enrollments WHERE class_id = 1`).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf(`Expected 2 enrollments in the new class but got %d`, count)
+	}
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, `/classes`, strings.NewReader(
+		`{"name":"Music","enrollments":{"student":7}}`)))
+	if resp.Code != http.StatusUnprocessableEntity {
+		t.Errorf(`Expected "422" for an invalid nested row but got %d for status code with body %s`, resp.Code, resp.Body.String())
+	}
+	err = db.QueryRow(`SELECT COUNT(*) FROM classes`).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf(`Expected the failed class to be rolled back but found %d classes`, count)
+	}
+}
+
+func TestEncoders(t *testing.T) {
+	db, err := sql.Open(`sqlite3`, "file:encoders.db?cache=shared&mode=memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE TABLE files(file_id INTEGER PRIMARY KEY AUTOINCREMENT, body BLOB, created DATETIME, public BOOLEAN);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO files(body, created, public) VALUES(x'cafe', '2020-01-02 03:04:05', 1);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoders := &bartlett.Encoders{}
+	encoders.RegisterType(`DATETIME`, bartlett.EncodeRFC3339)
+	encoders.RegisterColumn(`files`, `body`, bartlett.EncodeHex)
+	b := bartlett.Bartlett{DB: db, Driver: &SQLite3{}, Tables: []bartlett.Table{{Name: `files`}}, Users: dummyUserProvider, Encoders: encoders}
+
+	resp := httptest.NewRecorder()
+	b.Handler().ServeHTTP(resp, httptest.NewRequest(http.MethodGet, `/files`, nil))
+	expected := `[{"body":"cafe","created":"2020-01-02T03:04:05Z","file_id":1,"public":true}]`
+	if resp.Body.String() != expected {
+		t.Errorf(`Expected %s but got %s`, expected, resp.Body.String())
+	}
+}
+
+func TestQuotedNames(t *testing.T) {
+	db, err := sql.Open(`sqlite3`, "file:quoted.db?cache=shared&mode=memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1) // ATTACH only applies to the connection it runs on.
+
+	for _, stmt := range []string{
+		`CREATE TABLE "order"("order id" INTEGER PRIMARY KEY AUTOINCREMENT, "group" TEXT NOT NULL)`,
+		`ATTACH DATABASE 'file:archive.db?cache=shared&mode=memory' AS archive`,
+		`CREATE TABLE archive.notes(note_id INTEGER PRIMARY KEY AUTOINCREMENT, body TEXT NOT NULL)`,
+		`INSERT INTO archive.notes(body) VALUES('old')`,
+	} {
+		if _, err = db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b := bartlett.Bartlett{
+		DB:     db,
+		Driver: &SQLite3{},
+		Tables: []bartlett.Table{
+			{Name: `order`, Writable: true},
+			{Name: `archive.notes`, Route: `notes`, Writable: true},
+		},
+		Users: dummyUserProvider,
+	}
+	handler := b.Handler()
+
+	for _, step := range []struct {
+		method, path, body string
+		expected           int
+	}{
+		{http.MethodPost, `/order`, `{"group":"a"}`, http.StatusOK},
+		{http.MethodPatch, `/order?group=eq.a`, `{"group":"b"}`, http.StatusOK},
+		{http.MethodGet, `/order?select=group&order=order%20id.desc`, ``, http.StatusOK},
+		{http.MethodGet, `/order/1`, ``, http.StatusOK},
+		{http.MethodPost, `/notes`, `{"body":"new"}`, http.StatusOK},
+		{http.MethodDelete, `/notes/1`, ``, http.StatusOK},
+		{http.MethodGet, `/archive.notes`, ``, http.StatusNotFound},
+	} {
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest(step.method, step.path, strings.NewReader(step.body)))
+		if resp.Code != step.expected {
+			t.Errorf(`Expected "%d" for %s %s but got %d with body %s`, step.expected, step.method, step.path, resp.Code, resp.Body.String())
+		}
+	}
+
+	var group string
+	if err = db.QueryRow(`SELECT "group" FROM "order"`).Scan(&group); err != nil || group != `b` {
+		t.Errorf(`Expected the order's group to be b but got %q, %v`, group, err)
+	}
+	var body string
+	if err = db.QueryRow(`SELECT body FROM archive.notes`).Scan(&body); err != nil || body != `new` {
+		t.Errorf(`Expected only the new note to be left but got %q, %v`, body, err)
+	}
+}
+
+func TestJSONColumnETag(t *testing.T) {
+	db, err := sql.Open(`sqlite3`, "file:jsonetag.db?cache=shared&mode=memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE TABLE docs(doc_id INTEGER PRIMARY KEY AUTOINCREMENT, data JSON);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO docs(data) VALUES('{"a":1}');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := bartlett.Bartlett{DB: db, Driver: &SQLite3{}, Tables: []bartlett.Table{{Name: `docs`, Writable: true}}, Users: dummyUserProvider}
+	handler := b.Handler()
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, `/docs/1`, nil))
+	etag := resp.Header().Get(`ETag`)
+	if resp.Code != http.StatusOK || etag == `` {
+		t.Fatalf(`Expected "200" with an ETag but got %d with ETag %q`, resp.Code, etag)
+	}
+
+	req := httptest.NewRequest(http.MethodPatch, `/docs/1`, strings.NewReader(`{"data":"{\"a\":2}"}`))
+	req.Header.Set(`If-Match`, etag)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d with body %s`, resp.Code, resp.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPatch, `/docs/1`, strings.NewReader(`{"data":"{\"a\":3}"}`))
+	req.Header.Set(`If-Match`, etag)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusPreconditionFailed {
+		t.Errorf(`Expected "412" for a stale ETag but got %d with body %s`, resp.Code, resp.Body.String())
+	}
+}
+
+func TestVersionETag(t *testing.T) {
+	db, err := sql.Open(`sqlite3`, "file:versionetag.db?cache=shared&mode=memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE TABLE notes(note_id INTEGER PRIMARY KEY AUTOINCREMENT, body TEXT, version INTEGER NOT NULL);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := bartlett.Bartlett{
+		DB:     db,
+		Driver: &SQLite3{},
+		Tables: []bartlett.Table{{Name: `notes`, Writable: true, Version: bartlett.VersionSpec{Name: `version`}}},
+		Users:  dummyUserProvider,
+	}
+	handler := b.Handler()
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, `/notes`, strings.NewReader(`[{"body":"a"},{"body":"b"}]`)))
+	if resp.Code != http.StatusOK {
+		t.Fatalf(`Expected "200" but got %d with body %s`, resp.Code, resp.Body.String())
+	}
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, `/notes/1`, nil))
+	etag := resp.Header().Get(`ETag`)
+	if resp.Code != http.StatusOK || etag == `` {
+		t.Fatalf(`Expected "200" with an ETag but got %d with ETag %q`, resp.Code, etag)
+	}
+
+	req := httptest.NewRequest(http.MethodPatch, `/notes?note_id=in.(1,2)`, strings.NewReader(`{"body":"c"}`))
+	req.Header.Set(`If-Match`, etag)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d with body %s`, resp.Code, resp.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPatch, `/notes/1`, strings.NewReader(`{"body":"d"}`))
+	req.Header.Set(`If-Match`, etag)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusPreconditionFailed {
+		t.Errorf(`Expected "412" for a stale ETag but got %d with body %s`, resp.Code, resp.Body.String())
+	}
+
+	var sum int
+	err = db.QueryRow(`SELECT SUM(version) FROM notes`).Scan(&sum)
+	if err != nil || sum != 4 {
+		t.Errorf(`Expected both rows at version 2 but got a sum of %d with error %v`, sum, err)
+	}
+}
+
+func TestPutTextKey(t *testing.T) {
+	db, err := sql.Open(`sqlite3`, "file:puttextkey.db?cache=shared&mode=memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE TABLE codes(code TEXT NOT NULL PRIMARY KEY, name TEXT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := bartlett.Bartlett{DB: db, Driver: &SQLite3{}, Tables: []bartlett.Table{{Name: `codes`, Writable: true}}, Users: dummyUserProvider}
+	handler := b.Handler()
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPut, `/codes/abc`, strings.NewReader(`{"name":"x"}`)))
+	if resp.Code != http.StatusCreated {
+		t.Errorf(`Expected "201" but got %d with body %s`, resp.Code, resp.Body.String())
+	}
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPut, `/codes/abc`, strings.NewReader(`{"name":"y"}`)))
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" but got %d with body %s`, resp.Code, resp.Body.String())
+	}
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPut, `/codes`, strings.NewReader(`{"name":"y"}`)))
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf(`Expected "400" without a key but got %d with body %s`, resp.Code, resp.Body.String())
+	}
+
+	var name string
+	err = db.QueryRow(`SELECT name FROM codes WHERE code = 'abc'`).Scan(&name)
+	if err != nil || name != `y` {
+		t.Errorf(`Expected the row to be replaced but got %q with error %v`, name, err)
+	}
+}
+
+func TestIdempotencyTable(t *testing.T) {
+	db, err := sql.Open(`sqlite3`, "file:idempotency.db?cache=shared&mode=memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	store := bartlett.IdempotencyTable{Name: `idempotency`, TTL: time.Hour}
+	err = store.Create(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	saved := bartlett.IdempotentResponse{Hash: `hash`, Status: 200, Body: []byte(`{}`), Time: time.Now()}
+	err = store.Save(ctx, tx, `abc`, 1, saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, ok, err := store.Load(ctx, tx, `abc`, 1)
+	if err != nil || !ok || resp.Status != 200 || resp.Time.Unix() != saved.Time.Unix() {
+		t.Errorf(`Expected the saved response but got %+v, %t, %v`, resp, ok, err)
 	}
 }
@@ -0,0 +1,112 @@
+package bartlett
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/buger/jsonparser"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// reservedParams are the query parameters Bartlett interprets itself. They never filter a column of the same name.
+var reservedParams = []string{`select`, `order`, `limit`, `offset`, `subscribe`, `include_deleted`, `restore`}
+
+// filterColumns returns the query parameters of r that name columns of the table.
+func (t Table) filterColumns(r *http.Request) []string {
+	var columns []string
+	for k := range r.URL.Query() {
+		if !sliceContains(reservedParams, k) {
+			columns = append(columns, k)
+		}
+	}
+
+	return t.validReadColumns(columns)
+}
+
+// strict reports whether a request that names unknown columns or parameters should be refused.
+// Requests override Bartlett.Strict with `Prefer: handling=strict` or `Prefer: handling=lenient`, as in RFC 7240.
+func (b Bartlett) strict(r *http.Request) bool {
+	for _, field := range r.Header.Values(`Prefer`) {
+		for _, pref := range strings.Split(field, `,`) {
+			switch strings.ToLower(strings.Join(strings.Fields(pref), ``)) {
+			case `handling=strict`:
+				return true
+			case `handling=lenient`:
+				return false
+			}
+		}
+	}
+
+	return b.Strict
+}
+
+// checkNames lists every query parameter, `select` column, `order` column and body key of r that the table doesn't have.
+// The body is read and replaced so that the handler can read it again.
+func (t Table) checkNames(r *http.Request) error {
+	var problems []string
+	report := func(kind string, names []string) {
+		if len(names) > 0 {
+			problems = append(problems, fmt.Sprintf(`unknown %s: %s`, kind, strings.Join(names, `, `)))
+		}
+	}
+
+	var params []string
+	for k := range r.URL.Query() {
+		if !sliceContains(reservedParams, k) && !t.readable(k) {
+			params = append(params, k)
+		}
+	}
+	sort.Strings(params)
+	report(`query parameters`, params)
+
+	var selected []string
+	for _, col := range strings.Split(r.URL.Query().Get(`select`), `,`) {
+		if col != `` && !t.readable(col) {
+			selected = append(selected, col)
+		}
+	}
+	report(`select columns`, selected)
+
+	var ordered []string
+	for _, spec := range strings.Split(r.URL.Query().Get(`order`), `,`) {
+		col := strings.SplitN(spec, `.`, 2)[0]
+		if col != `` && !t.readable(col) {
+			ordered = append(ordered, col)
+		}
+	}
+	report(`order columns`, ordered)
+
+	if r.Body != nil && (r.Method == http.MethodPost || r.Method == http.MethodPatch || r.Method == http.MethodPut) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		var keys []string
+		check := func(row []byte) {
+			_ = jsonparser.ObjectEach(row, func(key []byte, value []byte, dataType jsonparser.ValueType, offset int) error {
+				_, nested := t.relation(string(key))
+				if !sliceContains(t.columns, string(key)) && !nested && !sliceContains(keys, string(key)) {
+					keys = append(keys, string(key))
+				}
+				return nil
+			})
+		}
+		if len(body) > 0 && body[0] == '[' {
+			_, _ = jsonparser.ArrayEach(body, func(row []byte, dataType jsonparser.ValueType, offset int, err error) {
+				check(row)
+			})
+		} else {
+			check(body)
+		}
+		report(`body keys`, keys)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf(`%s`, strings.Join(problems, `; `))
+	}
+	return nil
+}
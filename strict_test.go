@@ -0,0 +1,90 @@
+package bartlett
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStrict(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	b := Bartlett{
+		DB:     db,
+		Driver: dummyDriver{},
+		Tables: []Table{{Name: `students`, Writable: true}},
+		Users:  dummyUserProvider,
+		Strict: true,
+	}
+	handler := b.Handler()
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, `/students?gade=eq.90&limit=5&select=name,nme&order=b.asc,agee.desc`, nil))
+	expected := `{"error":"unknown query parameters: gade; unknown select columns: nme; unknown order columns: agee"}`
+	if resp.Code != http.StatusBadRequest || resp.Body.String() != expected {
+		t.Errorf(`Expected "400" with %s but got %d with %s`, expected, resp.Code, resp.Body.String())
+	}
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, `/students`, strings.NewReader(`[{"name":"todd"},{"nme":"ned"}]`)))
+	if resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), `unknown body keys: nme`) {
+		t.Errorf(`Expected "400" for body key nme but got %d with %s`, resp.Code, resp.Body.String())
+	}
+
+	mock.ExpectQuery(`SELECT \* FROM students$`).WillReturnRows(sqlmock.NewRows([]string{`id`}))
+	req := httptest.NewRequest(http.MethodGet, `/students?gade=eq.90`, nil)
+	req.Header.Set(`Prefer`, `handling=lenient`)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" for a lenient request but got %d with %s`, resp.Code, resp.Body.String())
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO students \(name\) VALUES \(\?\)`).
+		WithArgs(`todd`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, `/students`, strings.NewReader(`{"name":"todd"}`)))
+	if resp.Code != http.StatusOK {
+		t.Errorf(`Expected "200" after the body was checked but got %d with %s`, resp.Code, resp.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestStrictPerRequest(t *testing.T) {
+	b := Bartlett{
+		Driver: dummyDriver{},
+		Tables: []Table{{Name: `students`, Writable: true}},
+		Users:  dummyUserProvider,
+	}
+	handler := b.Handler()
+
+	req := httptest.NewRequest(http.MethodDelete, `/students?nam=eq.todd`, nil)
+	req.Header.Set(`Prefer`, `return=minimal, handling=strict`)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), `unknown query parameters: nam`) {
+		t.Errorf(`Expected "400" for parameter nam but got %d with %s`, resp.Code, resp.Body.String())
+	}
+}
+
+func TestReservedParams(t *testing.T) {
+	tbl := Table{}
+	tbl.setColumns([]Column{{Name: `order`}, {Name: `grade`}})
+	req := httptest.NewRequest(http.MethodGet, `/grades?order=grade.asc&grade=eq.5`, nil)
+
+	columns := tbl.filterColumns(req)
+	if len(columns) != 1 || columns[0] != `grade` {
+		t.Errorf(`Expected only grade to be filtered but got %v`, columns)
+	}
+}
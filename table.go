@@ -3,6 +3,8 @@ package bartlett
 import (
 	sqrl "github.com/Masterminds/squirrel"
 	"github.com/buger/jsonparser"
+	"strings"
+	"time"
 )
 
 // A Table represents a table in the database.
@@ -10,12 +12,33 @@ import (
 // Writable determines whether the table allows INSERT, UPDATE, and DELETE queries. Default is read-only.
 // UserID is the name of column containing user IDs. It should match the output of the UserIDProvider passed to Bartlett.
 // If UserID is left blank, all rows will be available regardless of the UserIDProvider.
+// Timeout overrides Bartlett.Timeout for queries against this table.
+// Hooks run application code before and after each operation on the table.
+// Version names a column that identifies each revision of a row, for ETags and If-Match.
+// LastModified names a timestamp column whose latest value among the returned rows is sent as Last-Modified.
+// CacheControl is sent as the Cache-Control header of GET responses, eg `private, max-age=60`.
+// SoftDelete names a column that marks deleted rows, turning DELETE into an UPDATE.
+// Managed lists columns that the server fills in on INSERT or UPDATE, such as CreatedAt and CreatedBy.
+// Schema replaces the JSON Schema generated from the table's columns for validating POST and PATCH bodies.
+// Route is the path segment the table is served under in place of its Name, eg `students` for `school.students`.
 type Table struct {
-	columns  []string
-	Name     string
-	IDColumn IDSpec
-	Writable bool
-	UserID   string
+	columns      []string
+	columnInfo   []Column
+	relations    []relation
+	driver       Driver
+	Name         string
+	IDColumn     IDSpec
+	Writable     bool
+	UserID       string
+	Timeout      time.Duration
+	Hooks        []Hook
+	Version      VersionSpec
+	LastModified string
+	CacheControl string
+	SoftDelete   SoftDeleteSpec
+	Managed      []ManagedColumn
+	Schema       *JSONSchema
+	Route        string
 }
 
 // An IDSpec is used for primary keys that are generated by the application rather than the database.
@@ -26,47 +49,120 @@ type IDSpec struct {
 	Generator func() interface{}
 }
 
-func (t Table) prepareInsert(inputBody []byte, userID, rowID interface{}) sqrl.InsertBuilder {
-	query := sqrl.Insert(t.Name)
+// route is the path segment the table is served under.
+func (t Table) route() string {
+	if t.Route != `` {
+		return t.Route
+	}
+	return t.Name
+}
+
+// setColumns records the schema reported by the Driver.
+func (t *Table) setColumns(columns []Column) {
+	t.columnInfo = columns
+	t.columns = make([]string, len(columns))
+	for i, col := range columns {
+		t.columns[i] = col.Name
+	}
+}
+
+// primaryKey names the column that item routes such as `/students/42` look up.
+// IDColumn takes precedence; otherwise the driver must report exactly one primary key column.
+func (t Table) primaryKey() string {
+	if t.IDColumn.Name != `` {
+		return t.IDColumn.Name
+	}
+
+	key := ``
+	for _, col := range t.columnInfo {
+		if col.PrimaryKey {
+			if key != `` {
+				return `` // Composite keys cannot be addressed by a single path segment.
+			}
+			key = col.Name
+		}
+	}
+
+	return key
+}
+
+// withoutKey is a copy of body without the primary key, for writes that use the key to pick the row they change.
+func (t Table) withoutKey(body []byte) []byte {
+	return jsonparser.Delete(append([]byte(nil), body...), t.primaryKey())
+}
+
+// quote quotes a column name for the table's Driver, so that names such as `order` or `first name` work in SQL.
+func (t Table) quote(name string) string {
+	if t.driver == nil {
+		return name
+	}
+	return t.driver.QuoteIdent(name)
+}
+
+// sqlName is the table's name quoted for SQL. The schema of a `schema.table` name is quoted separately.
+func (t Table) sqlName() string {
+	parts := strings.SplitN(t.Name, `.`, 2)
+	for i, part := range parts {
+		parts[i] = t.quote(part)
+	}
+	return strings.Join(parts, `.`)
+}
+
+func (t Table) prepareInsert(inputBody []byte, userID, rowID interface{}, managed []columnValue) sqrl.InsertBuilder {
+	query := sqrl.Insert(t.sqlName())
 	validCols := t.validWriteColumns()
 	var vals []interface{}
 	_ = jsonparser.ObjectEach(inputBody, func(key []byte, val []byte, dataType jsonparser.ValueType, offset int) error {
 		if sliceContains(validCols, string(key)) {
-			query = query.Columns(string(key))
+			query = query.Columns(t.quote(string(key)))
 			vals = append(vals, string(val))
 		}
 		return nil
 	})
 
 	if rowID != nil {
-		query = query.Columns(t.IDColumn.Name)
+		query = query.Columns(t.quote(t.IDColumn.Name))
 		vals = append(vals, rowID)
 	}
 	if len(t.UserID) > 0 {
-		query = query.Columns(t.UserID)
+		query = query.Columns(t.quote(t.UserID))
 		vals = append(vals, userID)
 	}
+	if t.Version.Name != `` {
+		query = query.Columns(t.quote(t.Version.Name))
+		vals = append(vals, 1)
+	}
+	for _, col := range managed {
+		query = query.Columns(t.quote(col.Name))
+		vals = append(vals, col.Value)
+	}
 
 	return query.Values(vals...)
 }
 
-func (t Table) prepareUpdate(inputBody []byte, userID interface{}, query sqrl.UpdateBuilder) sqrl.UpdateBuilder {
+func (t Table) prepareUpdate(inputBody []byte, userID interface{}, query sqrl.UpdateBuilder, managed []columnValue) sqrl.UpdateBuilder {
 	validCols := t.validWriteColumns()
 	_ = jsonparser.ObjectEach(inputBody, func(key []byte, val []byte, dataType jsonparser.ValueType, offset int) error {
 		if sliceContains(validCols, string(key)) {
-			query = query.Set(string(key), val)
+			query = query.Set(t.quote(string(key)), val)
 		}
 		return nil
 	})
+	if t.Version.Name != `` {
+		query = query.Set(t.quote(t.Version.Name), nextVersion(t.quote(t.Version.Name)))
+	}
+	for _, col := range managed {
+		query = query.Set(t.quote(col.Name), col.Value)
+	}
 
 	return query
 }
 
-// validReadColumns strips out columns that are not part of the table schema.
+// validReadColumns strips out columns that are not part of the table schema, keeping JSON paths into those that are.
 func (t Table) validReadColumns(cols []string) []string {
 	var out []string
 	for _, col := range cols { // Iterate the potentially pathological input only once.
-		if sliceContains(t.columns, col) {
+		if t.readable(col) {
 			out = append(out, col)
 		}
 	}
@@ -74,14 +170,27 @@ func (t Table) validReadColumns(cols []string) []string {
 	return out
 }
 
-// validWriteColumns returns a slice of columns that are not UserID or IDColumn.
+func (t Table) isGenerated(name string) bool {
+	for _, col := range t.columnInfo {
+		if col.Name == name {
+			return col.Generated
+		}
+	}
+	return false
+}
+
+// validWriteColumns returns a slice of columns that API users may write: not UserID, IDColumn, Version, SoftDelete,
+// a managed column or a generated one.
 func (t Table) validWriteColumns() []string {
-	out := t.columns
-	for i, name := range out {
-		if name == t.UserID ||
-			name == t.IDColumn.Name {
-			out[i] = out[len(out)-1]
-			out = out[:len(out)-1]
+	out := make([]string, 0, len(t.columns)) // Never reorder t.columns; concurrent requests share it.
+	for _, name := range t.columns {
+		if name != t.UserID &&
+			name != t.IDColumn.Name &&
+			name != t.Version.Name &&
+			name != t.SoftDelete.Name &&
+			!t.isManaged(name) &&
+			!t.isGenerated(name) {
+			out = append(out, name)
 		}
 	}
 
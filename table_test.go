@@ -1,6 +1,8 @@
 package bartlett
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
@@ -12,7 +14,7 @@ func TestPrepareInsert(t *testing.T) {
 		Name:     `letters`,
 		Writable: true,
 	}
-	sql, args, err := tbl.prepareInsert([]byte(`{"a": "test", "b": 5723, "c": "disregard"}`), 1, nil).ToSql()
+	sql, args, err := tbl.prepareInsert([]byte(`{"a": "test", "b": 5723, "c": "disregard"}`), 1, nil, nil).ToSql()
 	if err != nil {
 		t.Errorf(err.Error())
 	}
@@ -33,7 +35,7 @@ func TestPrepareInsertUserID(t *testing.T) {
 		Writable: true,
 		UserID:   `userID`,
 	}
-	sql, args, err := tbl.prepareInsert([]byte(`{"a": "test", "b": 5723, "userID": "disregard"}`), 1, nil).ToSql()
+	sql, args, err := tbl.prepareInsert([]byte(`{"a": "test", "b": 5723, "userID": "disregard"}`), 1, nil, nil).ToSql()
 	if err != nil {
 		t.Errorf(err.Error())
 	}
@@ -65,7 +67,7 @@ func TestPrepareInsertIDColumn(t *testing.T) {
 		Name:     `letters`,
 		Writable: true,
 	}
-	sql, args, err := tbl.prepareInsert([]byte(`{"a": "test", "b": 5723, "letter_id": "disregard"}`), 1, 1).ToSql()
+	sql, args, err := tbl.prepareInsert([]byte(`{"a": "test", "b": 5723, "letter_id": "disregard"}`), 1, 1, nil).ToSql()
 	if err != nil {
 		t.Errorf(err.Error())
 	}
@@ -101,4 +103,49 @@ func TestValidWriteColumns(t *testing.T) {
 	if nonIDCols[0] != `a` || len(nonIDCols) != 3 {
 		t.Errorf(`Expected [a, b, c] but got %+v instead`, idCols)
 	}
+
+	generatedTable := Table{Name: `generated`, Writable: true}
+	generatedTable.setColumns([]Column{{Name: `a`}, {Name: `total`, Generated: true}})
+	generatedCols := generatedTable.validWriteColumns()
+	if len(generatedCols) != 1 || generatedCols[0] != `a` {
+		t.Errorf(`Expected [a] but got %+v instead`, generatedCols)
+	}
+}
+
+// quotingDriver quotes names the way MariaDB does.
+type quotingDriver struct {
+	dummyDriver
+}
+
+func (d quotingDriver) QuoteIdent(name string) string {
+	return "`" + name + "`"
+}
+
+func TestQuotedSQL(t *testing.T) {
+	tbl := Table{Name: `school.order`, UserID: `user id`, driver: quotingDriver{}}
+	tbl.setColumns([]Column{{Name: `order id`, PrimaryKey: true}, {Name: `group`}, {Name: `data`}})
+	req := httptest.NewRequest(http.MethodGet, `/order?select=group,data->>a&group=in.a,b&order=order%20id.desc`, nil)
+
+	b := Bartlett{Driver: quotingDriver{}, Users: dummyUserProvider}
+	builder, err := b.buildSelect(tbl, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, _, err := builder.ToSql()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "SELECT `group`, json_path(`data`, '$.a', true) AS `a` FROM `school`.`order` WHERE `group` IN (?,?) AND `user id` = ? ORDER BY `order id` DESC"
+	if query != expected {
+		t.Errorf(`Expected %s but got %s`, expected, query)
+	}
+
+	insert, _, err := tbl.prepareInsert([]byte(`{"group":"a"}`), 1, nil, nil).ToSql()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "INSERT INTO `school`.`order` (`group`,`user id`) VALUES (?,?)"
+	if insert != expected {
+		t.Errorf(`Expected %s but got %s`, expected, insert)
+	}
 }
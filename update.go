@@ -10,7 +10,10 @@ import (
 )
 
 func (b Bartlett) buildUpdate(t Table, r *http.Request, userID interface{}, body []byte) (sqrl.UpdateBuilder, error) {
-	query := t.prepareUpdate(body, userID, sqrl.Update(t.Name))
+	query := t.prepareUpdate(body, userID, sqrl.Update(t.sqlName()), b.managedValues(t, OpUpdate, r))
+	if t.SoftDelete.Name != `` && restoring(r) {
+		query = query.Set(t.quote(t.SoftDelete.Name), t.SoftDelete.unmark())
+	}
 	query, err := updateWhere(query, t, r)
 	if err != nil {
 		return query, err
@@ -19,7 +22,10 @@ func (b Bartlett) buildUpdate(t Table, r *http.Request, userID interface{}, body
 	query = updateLimit(query, r)
 
 	if t.UserID != `` && userID != nil {
-		query = query.Where(sqrl.Eq{t.UserID: userID})
+		query = query.Where(sqrl.Eq{t.quote(t.UserID): userID})
+	}
+	if filter, ok := t.deletedFilter(r); ok {
+		query = query.Where(filter)
 	}
 
 	return query, nil
@@ -54,33 +60,14 @@ func updateOrder(query sqrl.UpdateBuilder, t Table, r *http.Request) sqrl.Update
 func updateWhere(query sqrl.UpdateBuilder, t Table, r *http.Request) (sqrl.UpdateBuilder, error) {
 	var err error = nil
 	whereClauses := 0
-	i := 0
-	columns := make([]string, len(r.URL.Query()))
-	for k := range r.URL.Query() {
-		columns[i] = k
-		i++
+	if id, ok := itemID(r); ok {
+		query = query.Where(sqrl.Eq{t.quote(t.primaryKey()): id})
+		whereClauses++
 	}
-	columns = t.validReadColumns(columns)
-
-	for column, values := range r.URL.Query() {
-		if sliceContains(columns, column) {
-			for _, rawCond := range values {
-				parsedCond, val := parseSimpleWhereCond(rawCond)
-				var cond string
-				if parsedCond == `in` || parsedCond == `not.in` {
-					if parsedCond == `not.in` {
-						query = query.Where(sqrl.NotEq{column: whereIn(val)})
-					} else {
-						query = query.Where(sqrl.Eq{column: whereIn(val)})
-					}
-				} else {
-					cond = urlToWhereCond(column, parsedCond)
-					sqlCond, val := rectifyArg(cond, val)
-					query = query.Where(sqlCond, val)
-				}
-				whereClauses++
-			}
-		}
+	conds, _ := t.filters(r) // handleRoute has already refused filters that don't compile.
+	for _, cond := range conds {
+		query = query.Where(cond)
+		whereClauses++
 	}
 
 	if whereClauses == 0 {
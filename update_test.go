@@ -21,7 +21,7 @@ func TestUpdate(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	b := Bartlett{&sql.DB{}, dummyDriver{}, []Table{table}, dummyUserProvider}
+	b := Bartlett{DB: &sql.DB{}, Driver: dummyDriver{}, Tables: []Table{table}, Users: dummyUserProvider}
 
 	builder, err := b.buildUpdate(table, req, 1, []byte(`{"grade":25}`))
 	if err != nil {
@@ -47,7 +47,7 @@ func TestUpdateNeedsWhere(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	b := Bartlett{&sql.DB{}, dummyDriver{}, []Table{table}, dummyUserProvider}
+	b := Bartlett{DB: &sql.DB{}, Driver: dummyDriver{}, Tables: []Table{table}, Users: dummyUserProvider}
 
 	_, err = b.buildUpdate(table, req, 1, []byte{})
 	if err == nil {
@@ -0,0 +1,306 @@
+package bartlett
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// WebSocketOptions limits what a single WebSocket connection may ask of the server. Zero fields take the defaults.
+// MaxMessageBytes caps the size of an incoming message, 1 MiB by default.
+// MaxInFlight is how many requests run at once, 8 by default. Further messages wait until one finishes.
+// MaxSubscriptions caps open subscriptions, 16 by default.
+// SendQueue is how many outgoing messages may wait for a slow client, 64 by default.
+// A client that lets the queue fill up is disconnected.
+// CheckOrigin decides which origins may connect. By default only the server's own origin may.
+type WebSocketOptions struct {
+	MaxMessageBytes  int64
+	MaxInFlight      int
+	MaxSubscriptions int
+	SendQueue        int
+	CheckOrigin      func(r *http.Request) bool
+}
+
+// A wsRequest is one message from the client, or one operation of a batch.
+// Op is one of select, insert, update, delete, subscribe or unsubscribe.
+// Key addresses a single row like an item route. Query holds URL query parameters such as `grade=gt.90&select=name`.
+type wsRequest struct {
+	ID    string          `json:"id"`
+	Table string          `json:"table"`
+	Op    string          `json:"op"`
+	Key   string          `json:"key,omitempty"`
+	Query string          `json:"query,omitempty"`
+	Body  json.RawMessage `json:"body,omitempty"`
+}
+
+// A wsResponse answers the request with the same ID, or carries an event for the subscription with that ID.
+type wsResponse struct {
+	ID     string          `json:"id"`
+	Status int             `json:"status,omitempty"`
+	Event  Operation       `json:"event,omitempty"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+var wsMethods = map[string]string{
+	`select`: http.MethodGet,
+	`insert`: http.MethodPost,
+	`update`: http.MethodPatch,
+	`delete`: http.MethodDelete,
+}
+
+// WebSocketHandler serves queries and subscriptions for every table over one WebSocket connection per client.
+// Each request runs exactly as the equivalent HTTP request to Handler would, with the headers and cookies of the
+// upgrade request, so the UserIDProvider sees the same credentials.
+// The upgrade itself is refused with 403 when the UserIDProvider fails.
+func (b *Bartlett) WebSocketHandler(opts WebSocketOptions) http.Handler {
+	err := b.Refresh(context.Background())
+	if err != nil {
+		log.Println(err.Error())
+	}
+	opts.setDefaults()
+
+	upgrader := websocket.Upgrader{CheckOrigin: opts.CheckOrigin}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if b.Users != nil {
+			if _, err := b.Users(r); err != nil {
+				writeError(w, http.StatusForbidden, fmt.Errorf(`failed to generate userID: %v`, err))
+				return
+			}
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return // The upgrader has already responded.
+		}
+		defer conn.Close()
+
+		c := b.newWSConn(conn, r, opts)
+		go c.write()
+		c.read()
+	})
+}
+
+func (opts *WebSocketOptions) setDefaults() {
+	if opts.MaxMessageBytes <= 0 {
+		opts.MaxMessageBytes = 1 << 20
+	}
+	if opts.MaxInFlight <= 0 {
+		opts.MaxInFlight = 8
+	}
+	if opts.MaxSubscriptions <= 0 {
+		opts.MaxSubscriptions = 16
+	}
+	if opts.SendQueue <= 0 {
+		opts.SendQueue = 64
+	}
+}
+
+// wsConn is the state of one client connection.
+type wsConn struct {
+	b        Bartlett
+	conn     *websocket.Conn
+	upgrade  *http.Request
+	opts     WebSocketOptions
+	ctx      context.Context
+	cancel   context.CancelFunc
+	send     chan wsResponse
+	inFlight chan struct{}
+	mu       sync.Mutex
+	subs     map[string]context.CancelFunc
+}
+
+func (b *Bartlett) newWSConn(conn *websocket.Conn, r *http.Request, opts WebSocketOptions) *wsConn {
+	ctx, cancel := context.WithCancel(r.Context())
+	conn.SetReadLimit(opts.MaxMessageBytes)
+	return &wsConn{
+		b:        *b,
+		conn:     conn,
+		upgrade:  r,
+		opts:     opts,
+		ctx:      ctx,
+		cancel:   cancel,
+		send:     make(chan wsResponse, opts.SendQueue),
+		inFlight: make(chan struct{}, opts.MaxInFlight),
+		subs:     make(map[string]context.CancelFunc),
+	}
+}
+
+// read handles incoming messages until the connection closes.
+func (c *wsConn) read() {
+	defer c.cancel()
+
+	for {
+		_, msg, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req wsRequest
+		err = json.Unmarshal(msg, &req)
+		if err != nil {
+			c.reply(req.ID, http.StatusBadRequest, fmt.Errorf(`message is not a valid request: %v`, err))
+			continue
+		}
+
+		select {
+		case c.inFlight <- struct{}{}: // Blocks reading, and so the client, once MaxInFlight requests are running.
+		case <-c.ctx.Done():
+			return
+		}
+		go func() {
+			defer func() { <-c.inFlight }()
+			c.handle(req)
+		}()
+	}
+}
+
+// write sends queued messages until the connection closes.
+func (c *wsConn) write() {
+	for {
+		select {
+		case <-c.ctx.Done():
+			_ = c.conn.WriteControl(websocket.CloseMessage, []byte{}, time.Now().Add(time.Second))
+			return
+		case msg := <-c.send:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if c.conn.WriteJSON(msg) != nil {
+				c.cancel()
+				return
+			}
+		}
+	}
+}
+
+// enqueue queues msg for the client, disconnecting a client too slow to keep up.
+func (c *wsConn) enqueue(msg wsResponse) {
+	select {
+	case c.send <- msg:
+	case <-c.ctx.Done():
+	default:
+		c.cancel()
+	}
+}
+
+func (c *wsConn) reply(id string, status int, err error) {
+	body, _ := json.Marshal(struct {
+		Error string `json:"error"`
+	}{err.Error()})
+	c.enqueue(wsResponse{ID: id, Status: status, Body: body})
+}
+
+func (c *wsConn) handle(req wsRequest) {
+	switch req.Op {
+	case `subscribe`:
+		c.subscribe(req)
+		return
+	case `unsubscribe`:
+		c.mu.Lock()
+		cancel, ok := c.subs[req.ID]
+		delete(c.subs, req.ID)
+		c.mu.Unlock()
+		if !ok {
+			c.reply(req.ID, http.StatusNotFound, errors.New(`no such subscription`))
+			return
+		}
+		cancel()
+		c.enqueue(wsResponse{ID: req.ID, Status: http.StatusOK})
+		return
+	}
+
+	method, ok := wsMethods[req.Op]
+	if !ok {
+		c.reply(req.ID, http.StatusBadRequest, fmt.Errorf(`unknown op %q`, req.Op))
+		return
+	}
+
+	buf := newBufferedWriter()
+	c.b.serve(buf, c.request(req, method))
+
+	body := buf.body.Bytes()
+	if !json.Valid(body) {
+		body = nil
+	}
+	c.enqueue(wsResponse{ID: req.ID, Status: buf.status, Body: body})
+}
+
+// request builds the HTTP request equivalent to a message, carrying over the upgrade request's credentials.
+func (c *wsConn) request(req wsRequest, method string) *http.Request {
+	r := c.upgrade.Clone(c.ctx)
+	r.Method = method
+	r.URL.Path = `/` + req.Table
+	if req.Key != `` {
+		r.URL.Path += `/` + req.Key
+	}
+	r.URL.RawQuery = req.Query
+	r.Body = ioutil.NopCloser(bytes.NewReader(req.Body))
+	r.ContentLength = int64(len(req.Body))
+	r.Header.Del(`Accept`) // The upgrade request's Accept says nothing about the message.
+
+	return r
+}
+
+// subscribe forwards a table's change events to the client until unsubscribed or disconnected.
+// Events carry the ID of the subscribe message.
+func (c *wsConn) subscribe(req wsRequest) {
+	if c.b.Changes == nil {
+		c.reply(req.ID, http.StatusNotImplemented, errors.New(`change feed is not enabled`))
+		return
+	}
+	t, ok := c.b.state.routed(req.Table)
+	if !ok {
+		c.reply(req.ID, http.StatusNotFound, fmt.Errorf(`table %s not found`, req.Table))
+		return
+	}
+	r := c.request(req, http.MethodGet)
+	userID, err := c.b.writeUser(t, r)
+	if err != nil {
+		c.reply(req.ID, http.StatusForbidden, err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.mu.Lock()
+	if _, ok := c.subs[req.ID]; ok || len(c.subs) >= c.opts.MaxSubscriptions {
+		c.mu.Unlock()
+		cancel()
+		c.reply(req.ID, http.StatusTooManyRequests, errors.New(`subscription limit reached or ID already in use`))
+		return
+	}
+	c.subs[req.ID] = cancel
+	c.mu.Unlock()
+
+	events, err := c.b.Changes.Subscribe(ctx, t.Name)
+	if err != nil {
+		c.mu.Lock()
+		delete(c.subs, req.ID)
+		c.mu.Unlock()
+		cancel()
+		c.reply(req.ID, http.StatusInternalServerError, err)
+		return
+	}
+	c.enqueue(wsResponse{ID: req.ID, Status: http.StatusOK})
+
+	go func() {
+		for e := range events {
+			data, visible := c.b.changeData(t.Name, r, userID, e)
+			if visible {
+				c.enqueue(wsResponse{ID: req.ID, Event: e.Operation, Body: data})
+			}
+		}
+
+		if ctx.Err() == nil { // The source gave up on us rather than the client unsubscribing.
+			c.mu.Lock()
+			delete(c.subs, req.ID)
+			c.mu.Unlock()
+			cancel()
+			c.reply(req.ID, http.StatusGone, errors.New(`subscription ended`))
+		}
+	}()
+}
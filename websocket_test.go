@@ -0,0 +1,195 @@
+package bartlett
+
+import (
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	sqrl "github.com/Masterminds/squirrel"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func dialWebSocket(t *testing.T, b *Bartlett, opts WebSocketOptions) (*websocket.Conn, func()) {
+	server := httptest.NewServer(b.WebSocketHandler(opts))
+	conn, _, err := websocket.DefaultDialer.Dial(`ws`+strings.TrimPrefix(server.URL, `http`), nil)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	return conn, func() {
+		conn.Close()
+		server.Close()
+	}
+}
+
+func TestWebSocketQueries(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	b := Bartlett{
+		DB:     db,
+		Driver: rowDriver{},
+		Tables: []Table{{Name: `students`, Writable: true}},
+		Users:  dummyUserProvider,
+	}
+	conn, done := dialWebSocket(t, &b, WebSocketOptions{MaxInFlight: 1})
+	defer done()
+
+	mock.ExpectQuery(`SELECT name FROM students WHERE id = \?`).
+		WithArgs(`4`).
+		WillReturnRows(sqlmock.NewRows([]string{`name`}).AddRow(`todd`))
+	err = conn.WriteJSON(wsRequest{ID: `a`, Table: `students`, Op: `select`, Query: `id=eq.4&select=name`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp wsResponse
+	if err = conn.ReadJSON(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != `a` || resp.Status != http.StatusOK || string(resp.Body) != `[{"name":"todd"}]` {
+		t.Errorf(`Expected todd in response a but got %+v with body %s`, resp, resp.Body)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO students \(name\) VALUES \(\?\)`).
+		WithArgs(`ned`).
+		WillReturnResult(sqlmock.NewResult(5, 1))
+	mock.ExpectCommit()
+	err = conn.WriteJSON(wsRequest{ID: `b`, Table: `students`, Op: `insert`, Body: []byte(`{"name":"ned"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.ReadJSON(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != `b` || resp.Status != http.StatusOK || !strings.Contains(string(resp.Body), `"inserts":[5]`) {
+		t.Errorf(`Expected insert 5 in response b but got %+v with body %s`, resp, resp.Body)
+	}
+
+	err = conn.WriteJSON(wsRequest{ID: `c`, Table: `teachers`, Op: `select`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.ReadJSON(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != `c` || resp.Status != http.StatusNotFound {
+		t.Errorf(`Expected 404 for response c but got %+v`, resp)
+	}
+
+	err = conn.WriteMessage(websocket.TextMessage, []byte(`{"id": 7`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.ReadJSON(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != http.StatusBadRequest {
+		t.Errorf(`Expected 400 for malformed message but got %+v`, resp)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestWebSocketSubscribe(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	feed := &ChangeFeed{}
+	b := Bartlett{
+		DB:      db,
+		Driver:  rowDriver{},
+		Tables:  []Table{{Name: `students`}},
+		Users:   dummyUserProvider,
+		Changes: feed,
+	}
+	conn, done := dialWebSocket(t, &b, WebSocketOptions{MaxSubscriptions: 1})
+	defer done()
+
+	var resp wsResponse
+	for _, id := range []string{`s1`, `s2`} {
+		err = conn.WriteJSON(wsRequest{ID: id, Table: `students`, Op: `subscribe`})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = conn.ReadJSON(&resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if resp.ID != `s2` || resp.Status != http.StatusTooManyRequests {
+		t.Errorf(`Expected second subscription to be refused but got %+v`, resp)
+	}
+
+	mock.ExpectQuery(`SELECT \* FROM students WHERE id = \?`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{`id`}).AddRow(3))
+	feed.Publish(ChangeEvent{Table: `students`, Operation: OpUpdate, Key: 3})
+	if err = conn.ReadJSON(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != `s1` || resp.Event != OpUpdate || string(resp.Body) != `{"key":3,"row":{"id":3}}` {
+		t.Errorf(`Expected update event for s1 but got %+v with body %s`, resp, resp.Body)
+	}
+
+	// A Refresh after subscribing applies to the events that follow.
+	students, _ := b.state.table(`students`)
+	students.Hooks = []Hook{{Operations: []Operation{OpSelect}, Before: func(c *Call) error {
+		c.Select = c.Select.Where(sqrl.Eq{`hidden`: false})
+		return nil
+	}}}
+	b.state.mu.Lock()
+	b.state.tables = map[string]Table{`students`: students}
+	b.state.mu.Unlock()
+	mock.ExpectQuery(`SELECT \* FROM students WHERE id = \? AND hidden = \?`).
+		WithArgs(4, false).
+		WillReturnRows(sqlmock.NewRows([]string{`id`}).AddRow(4))
+	feed.Publish(ChangeEvent{Table: `students`, Operation: OpUpdate, Key: 4})
+	if err = conn.ReadJSON(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != `s1` || string(resp.Body) != `{"key":4,"row":{"id":4}}` {
+		t.Errorf(`Expected update event for s1 but got %+v with body %s`, resp, resp.Body)
+	}
+
+	err = conn.WriteJSON(wsRequest{ID: `s1`, Op: `unsubscribe`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.ReadJSON(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != `s1` || resp.Status != http.StatusOK {
+		t.Errorf(`Expected unsubscribe to succeed but got %+v`, resp)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestWebSocketForbidden(t *testing.T) {
+	b := Bartlett{
+		Driver: dummyDriver{},
+		Tables: []Table{{Name: `students`}},
+		Users: func(_ *http.Request) (interface{}, error) {
+			return nil, errors.New(`who are you`)
+		},
+	}
+	server := httptest.NewServer(b.WebSocketHandler(WebSocketOptions{}))
+	defer server.Close()
+
+	_, resp, err := websocket.DefaultDialer.Dial(`ws`+strings.TrimPrefix(server.URL, `http`), nil)
+	if err == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf(`Expected upgrade to be refused with 403 but got %v`, err)
+	}
+}
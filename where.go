@@ -3,19 +3,145 @@ package bartlett
 import (
 	"encoding/csv"
 	"fmt"
+	sqrl "github.com/Masterminds/squirrel"
+	"net/http"
 	"strings"
 )
 
-func parseSimpleWhereCond(rawCond string) (cond, val string) {
+// standardFilters is the SQL of the filter operators that a Driver may compile differently, as in `FilterSQL`.
+var standardFilters = map[string]string{
+	`nseq`:       `%s IS NOT DISTINCT FROM ?`,
+	`isdistinct`: `%s IS DISTINCT FROM ?`,
+	`ilike`:      `%s ILIKE ?`,
+	`is.null`:    `%s IS NULL`,
+	`is.true`:    `%s IS TRUE`,
+	`is.false`:   `%s IS FALSE`,
+	`is.unknown`: `%s IS UNKNOWN`,
+}
+
+// filters compiles every filter in r's query string, such as `grade=gt.90`, into a WHERE condition.
+func (t Table) filters(r *http.Request) ([]sqrl.Sqlizer, error) {
+	var conds []sqrl.Sqlizer
+	columns := t.filterColumns(r)
+	for column, values := range r.URL.Query() {
+		if !sliceContains(columns, column) {
+			continue
+		}
+		for _, rawCond := range values {
+			cond, err := t.filterCond(t.columnSQL(column), rawCond)
+			if err != nil {
+				return nil, fmt.Errorf(`filter on %s: %v`, column, err)
+			}
+			conds = append(conds, cond)
+		}
+	}
+
+	return conds, nil
+}
+
+// filterCond compiles a single filter. Any operator may be negated with a `not.` prefix.
+func (t Table) filterCond(column, rawCond string) (sqrl.Sqlizer, error) {
+	parsedCond, val, err := parseSimpleWhereCond(rawCond)
+	if err != nil {
+		return nil, err
+	}
+	if rawCond == parsedCond {
+		val = `` // Operators such as `isnull` take no value.
+	}
+	op := strings.TrimPrefix(parsedCond, `not.`)
+	negated := op != parsedCond
+	negate := func(cond sqrl.Sqlizer) sqrl.Sqlizer {
+		if !negated {
+			return cond
+		}
+		sql, args, err := cond.ToSql()
+		if err != nil {
+			return cond
+		}
+		return sqrl.Expr(`NOT (`+sql+`)`, args...)
+	}
+
+	switch op {
+	case `in`:
+		if negated {
+			return sqrl.NotEq{column: whereIn(val)}, nil
+		}
+		return sqrl.Eq{column: whereIn(val)}, nil
+	case `between`:
+		bounds := whereIn(val)
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf(`between takes two values, as in between.1,10`)
+		}
+		if negated {
+			return sqrl.Expr(column+` NOT BETWEEN ? AND ?`, bounds[0], bounds[1]), nil
+		}
+		return sqrl.Expr(column+` BETWEEN ? AND ?`, bounds[0], bounds[1]), nil
+	case `isnull`, `notnull`:
+		if val != `` {
+			return nil, fmt.Errorf(`%s takes no value`, op)
+		}
+		if (op == `notnull`) != negated {
+			return sqrl.Expr(column + ` IS NOT NULL`), nil
+		}
+		return sqrl.Expr(column + ` IS NULL`), nil
+	case `is`:
+		lit := strings.ToLower(val)
+		if _, ok := standardFilters[`is.`+lit]; !ok {
+			return nil, fmt.Errorf(`is takes null, true, false or unknown but got %q`, val)
+		}
+		return negate(sqrl.Expr(t.filterSQL(`is.`+lit, column))), nil
+	case `nseq`, `isdistinct`:
+		return negate(sqrl.Expr(t.filterSQL(op, column), literal(val))), nil
+	case `ilike`:
+		return negate(sqrl.Expr(t.filterSQL(op, column), strings.Replace(val, `*`, `%`, -1))), nil
+	}
+
+	cond := urlToWhereCond(column, parsedCond)
+	if cond == `` {
+		return nil, fmt.Errorf(`unknown operator %s`, parsedCond)
+	}
+	sqlCond, val := rectifyArg(cond, val)
+	return sqrl.Expr(sqlCond, val), nil
+}
+
+// filterSQL is the Driver's SQL for an operator in standardFilters, or else the standard SQL.
+func (t Table) filterSQL(op, column string) string {
+	if t.driver != nil {
+		if sql, ok := t.driver.FilterSQL(op, column); ok {
+			return sql
+		}
+	}
+	return fmt.Sprintf(standardFilters[op], column)
+}
+
+// literal reads the SQL literals null, true and false in a filter value. Anything else is a string.
+func literal(val string) interface{} {
+	switch strings.ToLower(val) {
+	case `null`:
+		return nil
+	case `true`:
+		return true
+	case `false`:
+		return false
+	default:
+		return val
+	}
+}
+
+// parseSimpleWhereCond splits a filter such as `not.eq.90` into its operator and value.
+func parseSimpleWhereCond(rawCond string) (cond, val string, err error) {
 	parts := strings.Split(rawCond, `.`)
 	if parts[0] == `not` {
+		if len(parts) < 2 || parts[1] == `` {
+			return ``, ``, fmt.Errorf(`not needs an operator, as in not.eq.90`)
+		}
 		cond = fmt.Sprintf(`%s.%s`, parts[0], parts[1])
 	} else {
 		cond = parts[0]
 	}
 
 	val = strings.Replace(rawCond, cond+`.`, ``, 1)
-	return cond, val
+	return cond, val, nil
 }
 
 func rectifyArg(cond, val string) (string, string) {
@@ -55,10 +181,6 @@ func urlToWhereCond(column, condition string) string {
 		return fmt.Sprintf(`%s LIKE ?`, column)
 	case `not.like`:
 		return fmt.Sprintf(`%s NOT LIKE ?`, column)
-	case `is`:
-		return fmt.Sprintf(`%s IS ?`, column)
-	case `not.is`:
-		return fmt.Sprintf(`%s IS NOT ?`, column)
 	default:
 		return ``
 	}
@@ -1,24 +1,34 @@
 package bartlett
 
 import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"testing"
 )
 
 func TestParseSimpleWhereCond(t *testing.T) {
-	cond, val := parseSimpleWhereCond(`eq.90`)
+	cond, val, _ := parseSimpleWhereCond(`eq.90`)
 	if cond != `eq` || val != `90` {
 		t.Errorf(`Expected eq, 90 but got %s, %s`, cond, val)
 	}
 
-	cond, val = parseSimpleWhereCond(`not.eq.90`)
+	cond, val, _ = parseSimpleWhereCond(`not.eq.90`)
 	if cond != `not.eq` || val != `90` {
 		t.Errorf(`Expected not.eq, 90 but got %s, %s`, cond, val)
 	}
 
-	cond, val = parseSimpleWhereCond(`not.eq.hello,how.are.you`)
+	cond, val, _ = parseSimpleWhereCond(`not.eq.hello,how.are.you`)
 	if cond != `not.eq` || val != `hello,how.are.you` {
 		t.Errorf(`Expected not.eq, hello,how.are.you but got %s, %s`, cond, val)
 	}
+
+	for _, raw := range []string{`not`, `not.`} {
+		if _, _, err := parseSimpleWhereCond(raw); err == nil {
+			t.Errorf(`Expected %s to be refused`, raw)
+		}
+	}
 }
 
 func TestWhereIn(t *testing.T) {
@@ -27,3 +37,79 @@ func TestWhereIn(t *testing.T) {
 		t.Errorf(`Expected [10 20 30] but got %+v`, vals)
 	}
 }
+
+// dialectDriver compiles null-safe equality the way MariaDB does.
+type dialectDriver struct {
+	dummyDriver
+}
+
+func (d dialectDriver) FilterSQL(op, column string) (string, bool) {
+	if op == `nseq` {
+		return column + ` <=> ?`, true
+	}
+	return ``, false
+}
+
+func TestFilterCond(t *testing.T) {
+	tbl := Table{Name: `students`}
+	cases := []struct {
+		raw  string
+		sql  string
+		args []interface{}
+	}{
+		{`eq.90`, `grade = ?`, []interface{}{`90`}},
+		{`is.null`, `grade IS NULL`, nil},
+		{`is.TRUE`, `grade IS TRUE`, nil},
+		{`not.is.unknown`, `NOT (grade IS UNKNOWN)`, nil},
+		{`isnull`, `grade IS NULL`, nil},
+		{`notnull`, `grade IS NOT NULL`, nil},
+		{`not.isnull`, `grade IS NOT NULL`, nil},
+		{`between.80,90`, `grade BETWEEN ? AND ?`, []interface{}{`80`, `90`}},
+		{`not.between.(80,90)`, `grade NOT BETWEEN ? AND ?`, []interface{}{`80`, `90`}},
+		{`nseq.null`, `grade IS NOT DISTINCT FROM ?`, []interface{}{nil}},
+		{`isdistinct.true`, `grade IS DISTINCT FROM ?`, []interface{}{true}},
+		{`not.isdistinct.90`, `NOT (grade IS DISTINCT FROM ?)`, []interface{}{`90`}},
+		{`ilike.a*`, `grade ILIKE ?`, []interface{}{`a%`}},
+		{`not.in.1,2`, `grade NOT IN (?,?)`, []interface{}{`1`, `2`}},
+	}
+	for _, c := range cases {
+		cond, err := tbl.filterCond(`grade`, c.raw)
+		if err != nil {
+			t.Errorf(`Expected %s to compile but got %v`, c.raw, err)
+			continue
+		}
+		sql, args, _ := cond.ToSql()
+		if sql != c.sql || !reflect.DeepEqual(args, c.args) {
+			t.Errorf(`Expected %s %v for %s but got %s %v`, c.sql, c.args, c.raw, sql, args)
+		}
+	}
+
+	for _, raw := range []string{`is.maybe`, `between.1`, `isnull.1`, `approx.90`, `not`, `not.`, `not.not`} {
+		if _, err := tbl.filterCond(`grade`, raw); err == nil {
+			t.Errorf(`Expected %s to be refused`, raw)
+		}
+	}
+
+	tbl.driver = dialectDriver{}
+	cond, _ := tbl.filterCond(`grade`, `nseq.90`)
+	if sql, _, _ := cond.ToSql(); sql != `grade <=> ?` {
+		t.Errorf(`Expected the driver's SQL but got %s`, sql)
+	}
+}
+
+func TestUnknownOperator(t *testing.T) {
+	b := Bartlett{DB: &sql.DB{}, Driver: dummyDriver{}, Tables: []Table{{Name: `students`}}, Users: dummyUserProvider}
+	resp := httptest.NewRecorder()
+	b.Handler().ServeHTTP(resp, httptest.NewRequest(http.MethodGet, `/students?name=approx.todd`, nil))
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf(`Expected "400" for an unknown operator but got %d for status code`, resp.Code)
+	}
+
+	for _, query := range []string{`name=not`, `name=not.`} {
+		resp = httptest.NewRecorder()
+		b.Handler().ServeHTTP(resp, httptest.NewRequest(http.MethodGet, `/students?`+query, nil))
+		if resp.Code != http.StatusBadRequest {
+			t.Errorf(`Expected "400" for %s but got %d for status code`, query, resp.Code)
+		}
+	}
+}